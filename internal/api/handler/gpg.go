@@ -1,68 +1,242 @@
 package handler
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"time"
 
 	"log/slog"
 
 	"github.com/ggicci/httpin"
+	"github.com/hibare/DomainHQ/internal/config"
+	"github.com/hibare/DomainHQ/internal/mailer"
 	"github.com/hibare/DomainHQ/internal/models"
+	"github.com/hibare/DomainHQ/internal/upstream"
 	"github.com/hibare/GoCommon/v2/pkg/errors"
 	commonHttp "github.com/hibare/GoCommon/v2/pkg/http"
 	"gorm.io/gorm"
 )
 
 type GPGLookupParams struct {
-	Op     string `in:"query=op"`
-	Search string `in:"query=search;required"`
+	Op          string `in:"query=op"`
+	Search      string `in:"query=search;required"`
+	Options     string `in:"query=options"`
+	Exact       string `in:"query=exact"`
+	Fingerprint string `in:"query=fingerprint"`
+}
+
+type GPGKeyParams struct {
+	Fingerprint string `in:"path=fingerprint"`
 }
 
 type GPGKeyAddParams struct {
 	KeyText string `in:"form=keytext"`
 }
 
-const OPGet = "get"
+const (
+	ContentTypePGPKeys = "application/pgp-keys"
+
+	// maxKeyUploadSize limits raw key uploads, the same as the form parser limits keytext.
+	maxKeyUploadSize = 10 << 20
+)
+
+const (
+	OPGet    = "get"
+	OPIndex  = "index"
+	OPVIndex = "vindex"
+)
 
-func GPGPubKeyLookup(tx *gorm.DB, w http.ResponseWriter, r *http.Request) {
+// GPGPubKeyLookup serves the HKP lookup operations. Keys not stored here are fetched from
+// keyservers when it is set, except for addresses in the configured domains, which this
+// server is authoritative for.
+func GPGPubKeyLookup(tx *gorm.DB, keyservers *upstream.Client, w http.ResponseWriter, r *http.Request) {
 	requestInput := r.Context().Value(httpin.Input).(*GPGLookupParams)
 
-	if requestInput.Op == OPGet {
-		key, err := models.LookupPubKey(tx, requestInput.Search)
-		if err != nil {
-			if err == gorm.ErrRecordNotFound {
-				commonHttp.WriteErrorResponse(w, http.StatusNotFound, fmt.Errorf("key not found"))
+	switch requestInput.Op {
+	case OPGet:
+		gpgPubKeyGet(tx, keyservers, w, r, requestInput)
+	case OPIndex, OPVIndex:
+		gpgPubKeyIndex(tx, w, r, requestInput)
+	default:
+		commonHttp.WriteErrorResponse(w, http.StatusBadRequest, fmt.Errorf("invalid op"))
+	}
+}
+
+func gpgPubKeyGet(tx *gorm.DB, keyservers *upstream.Client, w http.ResponseWriter, r *http.Request, requestInput *GPGLookupParams) {
+	keys, err := models.LookupPubKey(tx, requestInput.Search)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			if keyservers != nil && !models.OwnedEmail(requestInput.Search) {
+				gpgUpstreamGet(tx, keyservers, w, r, requestInput.Search)
 				return
 			}
-
-			slog.Error("Error looking up key", "error", err)
-			commonHttp.WriteErrorResponse(w, http.StatusInternalServerError, errors.ErrInternalServerError)
+			commonHttp.WriteErrorResponse(w, http.StatusNotFound, fmt.Errorf("key not found"))
 			return
 		}
-		w.Header().Set("Content-Type", "text/plain")
-		fmt.Fprint(w, key.PublicKey)
+
+		slog.Error("Error looking up key", "error", err)
+		commonHttp.WriteErrorResponse(w, http.StatusInternalServerError, errors.ErrInternalServerError)
 		return
+	}
+
+	contentType, armored := negotiateKeyring(r)
+	w.Header().Add("Vary", "Accept")
+	writeKeyring(w, r, keys, contentType, armored)
+}
+
+// negotiateKeyring picks the content type of an op=get answer and reports whether the keys
+// are armored, which they are only as text/plain.
+func negotiateKeyring(r *http.Request) (string, bool) {
+	contentType := negotiateContentType(r, "text/plain", ContentTypePGPKeys, ContentTypeOctetStream)
+	return contentType, contentType == "text/plain"
+}
+
+// writeKeyring writes the published form of the keys, armored or binary, with cache headers.
+func writeKeyring(w http.ResponseWriter, r *http.Request, keys []models.GPGPubKeyStore, contentType string, armored bool) {
+	var keyring []byte
+	var err error
+	if armored {
+		var text string
+		text, err = models.ArmorKeyring(keys)
+		keyring = []byte(text)
 	} else {
-		commonHttp.WriteErrorResponse(w, http.StatusBadRequest, fmt.Errorf("invalid op"))
+		keyring, err = models.BinaryKeyring(keys)
+	}
+	if err != nil {
+		slog.Error("Error exporting keys", "error", err)
+		commonHttp.WriteErrorResponse(w, http.StatusInternalServerError, errors.ErrInternalServerError)
+		return
+	}
+
+	etagParts := []string{contentType}
+	lastModified := time.Time{}
+	for _, key := range keys {
+		etagParts = append(etagParts, key.Fingerprint, key.UpdatedAt.UTC().Format(time.RFC3339Nano))
+		if key.UpdatedAt.After(lastModified) {
+			lastModified = key.UpdatedAt
+		}
+	}
+	if writeCacheHeaders(w, r, newETag(etagParts...), lastModified) {
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Write(keyring)
+}
+
+func gpgPubKeyIndex(tx *gorm.DB, w http.ResponseWriter, r *http.Request, requestInput *GPGLookupParams) {
+	keys, err := models.SearchPubKeys(tx, requestInput.Search, isOn(requestInput.Exact))
+	if err != nil {
+		slog.Error("Error searching keys", "error", err)
+		commonHttp.WriteErrorResponse(w, http.StatusInternalServerError, errors.ErrInternalServerError)
+		return
+	}
+	if len(keys) == 0 {
+		commonHttp.WriteErrorResponse(w, http.StatusNotFound, fmt.Errorf("key not found"))
+		return
+	}
+
+	index, err := newIndexKeys(keys)
+	if err != nil {
+		slog.Error("Error building key index", "error", err)
+		commonHttp.WriteErrorResponse(w, http.StatusInternalServerError, errors.ErrInternalServerError)
 		return
 	}
+
+	if hasOption(requestInput.Options, OptionMR) {
+		w.Header().Set("Content-Type", ContentTypeText)
+		writeMRIndex(w, index)
+		return
+	}
+
+	w.Header().Set("Content-Type", ContentTypeHTML)
+	if err := writeHTMLIndex(w, requestInput.Search, index, requestInput.Op == OPVIndex, isOn(requestInput.Fingerprint)); err != nil {
+		slog.Error("Error writing key index", "error", err)
+	}
+}
+
+// isRawKeyUpload reports whether the keys were posted as the request body instead of a form.
+func isRawKeyUpload(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == ContentTypePGPKeys || mediaType == ContentTypeOctetStream
 }
 
-func GPGPubKeyAdd(tx *gorm.DB, w http.ResponseWriter, r *http.Request) {
+// parseKeyUpload reads the uploaded keys, either armored in the keytext form field or as the
+// request body, which may hold binary packets or armor.
+func parseKeyUpload(w http.ResponseWriter, r *http.Request, requestInput *GPGKeyAddParams) ([]models.ParsedPubKey, error) {
+	if !isRawKeyUpload(r) {
+		return models.ParsePubKeys(requestInput.KeyText)
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxKeyUploadSize))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN ")) {
+		return models.ParsePubKeys(string(data))
+	}
+	return models.ParseBinaryPubKeys(data)
+}
+
+func GPGPubKeyAdd(tx *gorm.DB, m mailer.Mailer, w http.ResponseWriter, r *http.Request) {
 	requestInput := r.Context().Value(httpin.Input).(*GPGKeyAddParams)
 
-	parsedKey, err := models.ParsePubKey(requestInput.KeyText)
+	parsedKeys, err := parseKeyUpload(w, r, requestInput)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	importKeys(tx, m, w, r, parsedKeys)
+}
 
-	err = models.AddPubKey(tx, &parsedKey)
+// importKeys stores the parsed keys, mails out verification links when required and writes
+// the per-key results. The status is 400 only when every key was rejected.
+func importKeys(tx *gorm.DB, m mailer.Mailer, w http.ResponseWriter, r *http.Request, parsedKeys []models.ParsedPubKey) {
+	results, err := models.ImportPubKeys(tx, parsedKeys)
 	if err != nil {
 		slog.Error("Error adding key", "error", err)
 		commonHttp.WriteErrorResponse(w, http.StatusInternalServerError, errors.ErrInternalServerError)
 		return
 	}
 
-	commonHttp.WriteJSONResponse(w, http.StatusOK, "key added")
+	if config.Current.Verification.Enabled {
+		for i := range results {
+			if results[i].Status == models.KeyRejected {
+				continue
+			}
+
+			results[i].Unverified, err = sendVerificationEmails(r.Context(), tx, m, &parsedKeys[i].Key)
+			if err != nil {
+				slog.Error("Error sending verification emails", "error", err)
+				commonHttp.WriteErrorResponse(w, http.StatusInternalServerError, errors.ErrInternalServerError)
+				return
+			}
+		}
+	}
+
+	status := http.StatusBadRequest
+	for _, result := range results {
+		if result.Status != models.KeyRejected {
+			status = http.StatusOK
+			break
+		}
+	}
+
+	commonHttp.WriteJSONResponse(w, status, results)
+}
+
+// GPGPubKeyDelete soft deletes a key. Whether it can be uploaded again is up to the
+// DOMAIN_HQ_GPG_REFUSE_DELETED_KEYS policy.
+func GPGPubKeyDelete(tx *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	requestInput := r.Context().Value(httpin.Input).(*GPGKeyParams)
+
+	if err := models.DeletePubKey(tx, requestInput.Fingerprint); err != nil {
+		writeStoreError(w, err, "key not found")
+		return
+	}
+
+	commonHttp.WriteJSONResponse(w, http.StatusOK, "key deleted")
 }
@@ -0,0 +1,282 @@
+package handler
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/hibare/DomainHQ/internal/models"
+)
+
+const (
+	// OptionMR asks for the machine readable index (draft-shaw-openpgp-hkp section 5.2).
+	OptionMR = "mr"
+
+	ContentTypeHTML = "text/html; charset=utf-8"
+	ContentTypeText = "text/plain; charset=utf-8"
+)
+
+var algorithmAbbrevs = map[packet.PublicKeyAlgorithm]string{
+	packet.PubKeyAlgoRSA:            "rsa",
+	packet.PubKeyAlgoRSAEncryptOnly: "rsa",
+	packet.PubKeyAlgoRSASignOnly:    "rsa",
+	packet.PubKeyAlgoElGamal:        "elg",
+	packet.PubKeyAlgoDSA:            "dsa",
+	packet.PubKeyAlgoECDH:           "ecdh",
+	packet.PubKeyAlgoECDSA:          "ecdsa",
+	packet.PubKeyAlgoEdDSA:          "eddsa",
+	packet.PubKeyAlgoX25519:         "x25519",
+	packet.PubKeyAlgoX448:           "x448",
+	packet.PubKeyAlgoEd25519:        "ed25519",
+	packet.PubKeyAlgoEd448:          "ed448",
+}
+
+type indexSignature struct {
+	KeyID      string
+	CreatedAt  time.Time
+	Revocation bool
+}
+
+type indexUID struct {
+	UID        string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	Revoked    bool
+	Signatures []indexSignature
+}
+
+type indexSubkey struct {
+	KeyID     string
+	Algorithm packet.PublicKeyAlgorithm
+	BitLength int
+	Curve     string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	Revoked   bool
+	Expired   bool
+}
+
+type indexKey struct {
+	KeyID       string
+	Fingerprint string
+	Algorithm   packet.PublicKeyAlgorithm
+	BitLength   int
+	Curve       string
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+	Revoked     bool
+	Expired     bool
+	UIDs        []indexUID
+	Subkeys     []indexSubkey
+}
+
+// isOn reports whether an HKP boolean parameter is switched on.
+func isOn(v string) bool {
+	return strings.EqualFold(strings.TrimSpace(v), "on")
+}
+
+// hasOption reports whether the comma separated HKP options contain option.
+func hasOption(options, option string) bool {
+	for _, o := range strings.Split(options, ",") {
+		if strings.EqualFold(strings.TrimSpace(o), option) {
+			return true
+		}
+	}
+	return false
+}
+
+func newIndexSignature(sig *packet.Signature) indexSignature {
+	s := indexSignature{
+		CreatedAt:  sig.CreationTime,
+		Revocation: sig.SigType == packet.SigTypeCertificationRevocation,
+	}
+	if sig.IssuerKeyId != nil {
+		s.KeyID = fmt.Sprintf("%016X", *sig.IssuerKeyId)
+	}
+	return s
+}
+
+func newIndexKey(key *models.GPGPubKeyStore, entity *openpgp.Entity, now time.Time) indexKey {
+	pk := entity.PrimaryKey
+	algorithm := models.NewKeyAlgorithm(pk)
+
+	index := indexKey{
+		KeyID:       pk.KeyIdString(),
+		Fingerprint: fmt.Sprintf("%X", pk.Fingerprint),
+		Algorithm:   pk.PubKeyAlgo,
+		BitLength:   algorithm.BitLength,
+		Curve:       algorithm.Curve,
+		CreatedAt:   pk.CreationTime,
+		ExpiresAt:   models.KeyExpiry(entity),
+		Revoked:     entity.Revoked(now),
+	}
+	index.Expired = !index.ExpiresAt.IsZero() && index.ExpiresAt.Before(now)
+
+	for _, identity := range entity.Identities {
+		if !key.IsPublished(identity.UserId.Email) {
+			continue
+		}
+
+		uid := indexUID{UID: identity.Name, Revoked: identity.Revoked(now)}
+		if sig := identity.SelfSignature; sig != nil {
+			uid.CreatedAt = sig.CreationTime
+			if sig.KeyLifetimeSecs != nil && *sig.KeyLifetimeSecs > 0 {
+				uid.ExpiresAt = pk.CreationTime.Add(time.Duration(*sig.KeyLifetimeSecs) * time.Second)
+			}
+			uid.Signatures = append(uid.Signatures, newIndexSignature(sig))
+		}
+		for _, sig := range identity.Signatures {
+			uid.Signatures = append(uid.Signatures, newIndexSignature(sig))
+		}
+		for _, sig := range identity.Revocations {
+			uid.Signatures = append(uid.Signatures, newIndexSignature(sig))
+		}
+		index.UIDs = append(index.UIDs, uid)
+	}
+	slices.SortFunc(index.UIDs, func(a, b indexUID) int {
+		return strings.Compare(a.UID, b.UID)
+	})
+
+	for _, subkey := range entity.Subkeys {
+		algorithm := models.NewKeyAlgorithm(subkey.PublicKey)
+		sub := indexSubkey{
+			KeyID:     subkey.PublicKey.KeyIdString(),
+			Algorithm: subkey.PublicKey.PubKeyAlgo,
+			BitLength: algorithm.BitLength,
+			Curve:     algorithm.Curve,
+			CreatedAt: subkey.PublicKey.CreationTime,
+			Revoked:   subkey.Revoked(now),
+		}
+		if sig := subkey.Sig; sig != nil && sig.KeyLifetimeSecs != nil && *sig.KeyLifetimeSecs > 0 {
+			sub.ExpiresAt = sub.CreatedAt.Add(time.Duration(*sig.KeyLifetimeSecs) * time.Second)
+			sub.Expired = !sub.ExpiresAt.After(now)
+		}
+		index.Subkeys = append(index.Subkeys, sub)
+	}
+
+	return index
+}
+
+func (k *indexKey) flags() string {
+	flags := ""
+	if k.Revoked {
+		flags += "r"
+	}
+	if k.Expired {
+		flags += "e"
+	}
+	return flags
+}
+
+func (u *indexUID) flags(now time.Time) string {
+	flags := ""
+	if u.Revoked {
+		flags += "r"
+	}
+	if !u.ExpiresAt.IsZero() && u.ExpiresAt.Before(now) {
+		flags += "e"
+	}
+	return flags
+}
+
+// newIndexKeys parses the stored keys for listing.
+func newIndexKeys(keys []models.GPGPubKeyStore) ([]indexKey, error) {
+	now := time.Now()
+	index := []indexKey{}
+	for i := range keys {
+		entity, err := keys[i].Entity()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse key %s: %w", keys[i].Fingerprint, err)
+		}
+		index = append(index, newIndexKey(&keys[i], entity, now))
+	}
+	return index, nil
+}
+
+func unixTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return fmt.Sprint(t.Unix())
+}
+
+// escapeMRField percent encodes the characters that are not allowed in a machine readable field.
+func escapeMRField(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if c == ':' || c == '%' || c < 0x20 || c == 0x7f {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// writeMRIndex writes the machine readable index (draft-shaw-openpgp-hkp section 5.2).
+func writeMRIndex(w io.Writer, keys []indexKey) {
+	now := time.Now()
+	fmt.Fprintf(w, "info:1:%d\n", len(keys))
+	for _, key := range keys {
+		fmt.Fprintf(w, "pub:%s:%d:%d:%s:%s:%s\n", key.Fingerprint, key.Algorithm, key.BitLength, unixTime(key.CreatedAt), unixTime(key.ExpiresAt), key.flags())
+		for _, uid := range key.UIDs {
+			fmt.Fprintf(w, "uid:%s:%s:%s:%s\n", escapeMRField(uid.UID), unixTime(uid.CreatedAt), unixTime(uid.ExpiresAt), uid.flags(now))
+		}
+	}
+}
+
+var indexTemplate = template.Must(template.New("index").Funcs(template.FuncMap{
+	"date": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.UTC().Format(time.DateOnly)
+	},
+	"spaced": func(fingerprint string) string {
+		groups := []string{}
+		for i := 0; i < len(fingerprint); i += 4 {
+			groups = append(groups, fingerprint[i:min(i+4, len(fingerprint))])
+		}
+		return strings.Join(groups, " ")
+	},
+	"algo": func(a packet.PublicKeyAlgorithm) string {
+		if name, ok := algorithmAbbrevs[a]; ok {
+			return name
+		}
+		return fmt.Sprint(int(a))
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Search results for '{{.Search}}'</title>
+</head>
+<body>
+<h1>Search results for '{{.Search}}'</h1>
+{{range .Keys}}<pre>
+pub  {{if .Curve}}{{.Curve}}{{else}}{{algo .Algorithm}}{{.BitLength}}{{end}}/<a href="/pks/lookup?op=get&search=0x{{.Fingerprint}}">{{.KeyID}}</a> {{date .CreatedAt}}{{if .ExpiresAt.IsZero}}{{else}} [expires: {{date .ExpiresAt}}]{{end}}{{if .Revoked}} [revoked]{{end}}{{if .Expired}} [expired]{{end}}
+{{if $.Fingerprint}}     Key fingerprint = {{spaced .Fingerprint}}
+{{end}}{{range .UIDs}}uid  {{.UID}}{{if .Revoked}} [revoked]{{end}}
+{{if $.Verbose}}{{range .Signatures}}sig{{if .Revocation}} rev{{else}}    {{end}} {{.KeyID}} {{date .CreatedAt}}
+{{end}}{{end}}{{end}}{{range .Subkeys}}sub  {{if .Curve}}{{.Curve}}{{else}}{{algo .Algorithm}}{{.BitLength}}{{end}}/{{.KeyID}} {{date .CreatedAt}}{{if .ExpiresAt.IsZero}}{{else}} [expires: {{date .ExpiresAt}}]{{end}}{{if .Revoked}} [revoked]{{end}}{{if .Expired}} [expired]{{end}}
+{{end}}</pre>
+<hr>
+{{end}}</body>
+</html>
+`))
+
+// writeHTMLIndex writes the human readable index, listing signatures when verbose is set
+// and the full fingerprints when fingerprint is set.
+func writeHTMLIndex(w io.Writer, search string, keys []indexKey, verbose, fingerprint bool) error {
+	return indexTemplate.Execute(w, struct {
+		Search      string
+		Keys        []indexKey
+		Verbose     bool
+		Fingerprint bool
+	}{search, keys, verbose, fingerprint})
+}
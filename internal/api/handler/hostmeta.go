@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/hibare/DomainHQ/internal/config"
+	"github.com/hibare/GoCommon/v2/pkg/errors"
+	commonHttp "github.com/hibare/GoCommon/v2/pkg/http"
+)
+
+const LRDDRel = "lrdd"
+
+// publicBaseURL returns the externally visible base URL of the server.
+func publicBaseURL(r *http.Request) string {
+	if config.Current.Server.PublicURL != "" {
+		return config.Current.Server.PublicURL
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host
+}
+
+func newHostMetaResponse(r *http.Request) *WebFingerResponse {
+	template := publicBaseURL(r) + "/.well-known/webfinger?resource={uri}"
+	return &WebFingerResponse{
+		Links: []Link{
+			{Rel: LRDDRel, Type: ContentTypeJRD, Template: template},
+			{Rel: LRDDRel, Type: ContentTypeXRD, Template: template},
+		},
+	}
+}
+
+// writeHostMeta writes the host-meta document. Without a configured public URL the links
+// are built from the request Host and X-Forwarded-Proto, so the document must not be kept
+// by shared caches.
+func writeHostMeta(w http.ResponseWriter, r *http.Request, contentType string) {
+	resp := newHostMetaResponse(r)
+	if config.Current.Server.PublicURL != "" {
+		writeDescriptor(w, r, resp, contentType, time.Time{})
+		return
+	}
+
+	body, err := encodeDescriptor(resp, contentType)
+	if err != nil {
+		slog.Error("Error encoding response", "error", err)
+		commonHttp.WriteErrorResponse(w, http.StatusInternalServerError, errors.ErrInternalServerError)
+		return
+	}
+
+	w.Header().Add("Vary", "Accept, Host, X-Forwarded-Proto")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// HostMeta serves the RFC 6415 host-meta document, as XRD unless JSON is asked for.
+func HostMeta(w http.ResponseWriter, r *http.Request) {
+	contentType := negotiateContentType(r, ContentTypeXRD, ContentTypeXML, ContentTypeJRD, ContentTypeJSON)
+	writeHostMeta(w, r, contentType)
+}
+
+// HostMetaJSON serves the JSON variant of the host-meta document.
+func HostMetaJSON(w http.ResponseWriter, r *http.Request) {
+	writeHostMeta(w, r, ContentTypeJSON)
+}
@@ -0,0 +1,196 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ggicci/httpin"
+	"github.com/hibare/DomainHQ/internal/mailer"
+	"github.com/hibare/DomainHQ/internal/models"
+	commonHttp "github.com/hibare/GoCommon/v2/pkg/http"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultKeyPageSize = 50
+	maxKeyPageSize     = 200
+)
+
+// KeyResponse is the JSON representation of a key. Only published user IDs are included.
+type KeyResponse struct {
+	Fingerprint string             `json:"fingerprint"`
+	KeyID       string             `json:"key_id"`
+	KeyIDShort  string             `json:"key_id_short"`
+	Version     int                `json:"version"`
+	Algorithm   string             `json:"algorithm"`
+	BitLength   int                `json:"bit_length"`
+	Curve       string             `json:"curve,omitempty"`
+	CurveOID    string             `json:"curve_oid,omitempty"`
+	CreatedAt   time.Time          `json:"created_at"`
+	ExpiresAt   *time.Time         `json:"expires_at"`
+	UpdatedAt   time.Time          `json:"updated_at"`
+	Revoked     bool               `json:"revoked"`
+	Expired     bool               `json:"expired"`
+	Users       []models.GPGUsers  `json:"users"`
+	Subkeys     []models.GPGSubkey `json:"subkeys"`
+}
+
+func newKeyResponse(key *models.GPGPubKeyStore, now time.Time) KeyResponse {
+	resp := KeyResponse{
+		Fingerprint: key.Fingerprint,
+		KeyID:       key.KeyID,
+		KeyIDShort:  key.KeyIDShort,
+		Version:     key.Version,
+		Algorithm:   key.Algorithm,
+		BitLength:   key.BitLength,
+		Curve:       key.Curve,
+		CurveOID:    key.CurveOID,
+		CreatedAt:   key.CreatedAt,
+		ExpiresAt:   key.ExpiresAt,
+		UpdatedAt:   key.UpdatedAt,
+		Revoked:     key.Revoked,
+		Expired:     key.Expired || (key.ExpiresAt != nil && !key.ExpiresAt.After(now)),
+		Users:       []models.GPGUsers{},
+		Subkeys:     key.Subkeys,
+	}
+	for _, user := range key.Users {
+		if key.IsPublished(user.Email) {
+			resp.Users = append(resp.Users, user)
+		}
+	}
+	if resp.Subkeys == nil {
+		resp.Subkeys = []models.GPGSubkey{}
+	}
+	return resp
+}
+
+type KeyListResponse struct {
+	Keys       []KeyResponse `json:"keys"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+type KeyListParams struct {
+	Email   string `in:"query=email"`
+	Domain  string `in:"query=domain"`
+	Revoked *bool  `in:"query=revoked"`
+	Expired *bool  `in:"query=expired"`
+	Cursor  string `in:"query=cursor"`
+	Limit   int    `in:"query=limit"`
+}
+
+type KeyParams struct {
+	ID string `in:"path=id"`
+}
+
+type KeyUploadPayload struct {
+	KeyText string `json:"keytext"`
+}
+
+func (p *KeyUploadPayload) Validate() error {
+	if p == nil {
+		return errMissingBody
+	}
+	if strings.TrimSpace(p.KeyText) == "" {
+		return fmt.Errorf("keytext is required")
+	}
+	return nil
+}
+
+type KeyUploadParams struct {
+	Payload *KeyUploadPayload `in:"body=json"`
+}
+
+func ListKeys(tx *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	requestInput := r.Context().Value(httpin.Input).(*KeyListParams)
+
+	limit := requestInput.Limit
+	if limit == 0 {
+		limit = defaultKeyPageSize
+	}
+	if limit < 0 || limit > maxKeyPageSize {
+		commonHttp.WriteErrorResponse(w, http.StatusBadRequest, fmt.Errorf("limit must be between 1 and %d", maxKeyPageSize))
+		return
+	}
+
+	filter := models.PubKeyFilter{
+		Email:   requestInput.Email,
+		Domain:  requestInput.Domain,
+		Revoked: requestInput.Revoked,
+		Expired: requestInput.Expired,
+	}
+	keys, next, err := models.ListPubKeys(tx, filter, requestInput.Cursor, limit)
+	if err != nil {
+		writeStoreError(w, err, "key not found")
+		return
+	}
+
+	now := time.Now()
+	resp := KeyListResponse{Keys: []KeyResponse{}, NextCursor: next}
+	for i := range keys {
+		resp.Keys = append(resp.Keys, newKeyResponse(&keys[i], now))
+	}
+	commonHttp.WriteJSONResponse(w, http.StatusOK, resp)
+}
+
+// lookupKey resolves a fingerprint, key ID or email to a single key. A search matching several
+// keys is a conflict, the list endpoint returns all of them. The error response has been
+// written when it returns false.
+func lookupKey(tx *gorm.DB, w http.ResponseWriter, search string) (*models.GPGPubKeyStore, bool) {
+	keys, err := models.LookupPubKey(tx, search)
+	if err != nil {
+		writeStoreError(w, err, "key not found")
+		return nil, false
+	}
+	if len(keys) > 1 {
+		commonHttp.WriteErrorResponse(w, http.StatusConflict, fmt.Errorf("%d keys match, search by fingerprint instead", len(keys)))
+		return nil, false
+	}
+	return &keys[0], true
+}
+
+// GetKey looks up a single key by fingerprint, key ID or email.
+func GetKey(tx *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	requestInput := r.Context().Value(httpin.Input).(*KeyParams)
+
+	key, ok := lookupKey(tx, w, requestInput.ID)
+	if !ok {
+		return
+	}
+
+	commonHttp.WriteJSONResponse(w, http.StatusOK, newKeyResponse(key, time.Now()))
+}
+
+func UploadKeys(tx *gorm.DB, m mailer.Mailer, w http.ResponseWriter, r *http.Request) {
+	requestInput := r.Context().Value(httpin.Input).(*KeyUploadParams)
+
+	if err := requestInput.Payload.Validate(); err != nil {
+		commonHttp.WriteErrorResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	parsedKeys, err := models.ParsePubKeys(requestInput.Payload.KeyText)
+	if err != nil {
+		commonHttp.WriteErrorResponse(w, http.StatusBadRequest, err)
+		return
+	}
+	importKeys(tx, m, w, r, parsedKeys)
+}
+
+// DeleteKey soft deletes a single key, found the same way GetKey finds it.
+func DeleteKey(tx *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	requestInput := r.Context().Value(httpin.Input).(*KeyParams)
+
+	key, ok := lookupKey(tx, w, requestInput.ID)
+	if !ok {
+		return
+	}
+
+	if err := models.DeletePubKey(tx, key.Fingerprint); err != nil {
+		writeStoreError(w, err, "key not found")
+		return
+	}
+
+	commonHttp.WriteJSONResponse(w, http.StatusOK, "key deleted")
+}
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+func parseAccept(header string) []acceptRange {
+	ranges := []acceptRange{}
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		ranges = append(ranges, acceptRange{mediaType: mediaType, q: q})
+	}
+	return ranges
+}
+
+// matchAccept returns the quality and specificity of the most specific range matching the offer.
+func matchAccept(ranges []acceptRange, offer string) (float64, int) {
+	q, specificity := 0.0, -1
+	offerType, _, _ := strings.Cut(offer, "/")
+	for _, ar := range ranges {
+		s := -1
+		switch {
+		case ar.mediaType == offer:
+			s = 2
+		case ar.mediaType == offerType+"/*":
+			s = 1
+		case ar.mediaType == "*/*":
+			s = 0
+		}
+		if s > specificity {
+			q, specificity = ar.q, s
+		}
+	}
+	return q, specificity
+}
+
+// negotiateContentType picks the offer preferred by the Accept header. The first offer is
+// the default when the header is missing or accepts none of the offers.
+func negotiateContentType(r *http.Request, offers ...string) string {
+	ranges := parseAccept(r.Header.Get("Accept"))
+	if len(ranges) == 0 {
+		return offers[0]
+	}
+
+	best, bestQ, bestSpecificity := offers[0], 0.0, -1
+	for _, offer := range offers {
+		q, specificity := matchAccept(ranges, offer)
+		if q > bestQ || (q == bestQ && q > 0 && specificity > bestSpecificity) {
+			best, bestQ, bestSpecificity = offer, q, specificity
+		}
+	}
+	return best
+}
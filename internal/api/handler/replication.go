@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/ggicci/httpin"
+	"github.com/hibare/DomainHQ/internal/config"
+	"github.com/hibare/DomainHQ/internal/models"
+	"github.com/hibare/DomainHQ/internal/replication"
+	"github.com/hibare/GoCommon/v2/pkg/errors"
+	commonHttp "github.com/hibare/GoCommon/v2/pkg/http"
+	"gorm.io/gorm"
+)
+
+const maxChangePageSize = 500
+
+type ReplicationChangesParams struct {
+	Cursor string `in:"query=cursor"`
+	Limit  int    `in:"query=limit"`
+}
+
+type ReplicationStatusResponse struct {
+	Peers []replication.PeerStatus `json:"peers"`
+}
+
+// ReplicationChanges serves the change feed pulled by replicating peers. Changes younger than
+// the settle time are held back until transactions stamped before them have committed.
+func ReplicationChanges(tx *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	requestInput := r.Context().Value(httpin.Input).(*ReplicationChangesParams)
+
+	limit := requestInput.Limit
+	if limit == 0 {
+		limit = replication.BatchSize
+	}
+	if limit < 0 || limit > maxChangePageSize {
+		commonHttp.WriteErrorResponse(w, http.StatusBadRequest, fmt.Errorf("limit must be between 1 and %d", maxChangePageSize))
+		return
+	}
+
+	settled := time.Now().Add(-config.Current.Replication.Settle)
+	changes, next, more, err := models.ListKeyChanges(tx, requestInput.Cursor, limit, settled)
+	if err != nil {
+		if err == models.ErrInvalidCursor {
+			commonHttp.WriteErrorResponse(w, http.StatusBadRequest, err)
+			return
+		}
+		writeStoreError(w, err, "key not found")
+		return
+	}
+
+	commonHttp.WriteJSONResponse(w, http.StatusOK, replication.ChangeFeed{Changes: changes, NextCursor: next, More: more})
+}
+
+// ReplicationStatus reports how far behind each peer this instance is.
+func ReplicationStatus(syncer *replication.Syncer, w http.ResponseWriter, r *http.Request) {
+	resp := ReplicationStatusResponse{Peers: []replication.PeerStatus{}}
+	if syncer != nil {
+		peers, err := syncer.Status(time.Now())
+		if err != nil {
+			slog.Error("Error loading replication status", "error", err)
+			commonHttp.WriteErrorResponse(w, http.StatusInternalServerError, errors.ErrInternalServerError)
+			return
+		}
+		resp.Peers = peers
+	}
+
+	commonHttp.WriteJSONResponse(w, http.StatusOK, resp)
+}
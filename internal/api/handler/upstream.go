@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/hibare/DomainHQ/internal/config"
+	"github.com/hibare/DomainHQ/internal/models"
+	"github.com/hibare/DomainHQ/internal/upstream"
+	"github.com/hibare/GoCommon/v2/pkg/errors"
+	commonHttp "github.com/hibare/GoCommon/v2/pkg/http"
+	"gorm.io/gorm"
+)
+
+// Headers marking keys served from an upstream keyserver rather than from this one.
+const (
+	HeaderUpstreamSource = "X-Upstream-Source"
+	HeaderAuthoritative  = "X-Authoritative"
+)
+
+// upstreamKey returns the upstream keys for a search from the cache, fetching them on a miss.
+// Searches no keyserver has a key for are remembered for a while too.
+func upstreamKey(tx *gorm.DB, keyservers *upstream.Client, r *http.Request, search string) (*models.GPGUpstreamKey, error) {
+	now := time.Now()
+	key, err := models.GetUpstreamKey(tx, search, now)
+	switch {
+	case err == nil && !key.Found():
+		return nil, upstream.ErrNotFound
+	case err != gorm.ErrRecordNotFound:
+		return key, err
+	}
+
+	keyText, source, err := keyservers.Fetch(r.Context(), search)
+	if err == nil {
+		keyText, err = models.SanitizeUpstreamKeys(keyText, search)
+		if err != nil {
+			slog.Warn("Discarding upstream response", "source", source, "error", err)
+			err = upstream.ErrNotFound
+		}
+	}
+	if err == upstream.ErrNotFound {
+		miss := &models.GPGUpstreamKey{Search: search, FetchedAt: now, ExpiresAt: now.Add(config.Current.Upstream.MissTTL)}
+		if err := models.SaveUpstreamKey(tx, miss); err != nil {
+			return nil, err
+		}
+		return nil, upstream.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key = &models.GPGUpstreamKey{
+		Search:    search,
+		Source:    source,
+		PublicKey: keyText,
+		FetchedAt: now,
+		ExpiresAt: now.Add(config.Current.Upstream.CacheTTL),
+	}
+	if err := models.SaveUpstreamKey(tx, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// gpgUpstreamGet answers op=get from the upstream keyservers for keys not stored here.
+func gpgUpstreamGet(tx *gorm.DB, keyservers *upstream.Client, w http.ResponseWriter, r *http.Request, search string) {
+	key, err := upstreamKey(tx, keyservers, r, search)
+	if err != nil {
+		if err == upstream.ErrNotFound {
+			commonHttp.WriteErrorResponse(w, http.StatusNotFound, fmt.Errorf("key not found"))
+			return
+		}
+
+		slog.Error("Error looking up key upstream", "error", err)
+		commonHttp.WriteErrorResponse(w, http.StatusBadGateway, fmt.Errorf("upstream keyserver unavailable"))
+		return
+	}
+
+	// Answer in the same form as for local keys, the client cannot tell them apart otherwise.
+	contentType, armored := negotiateKeyring(r)
+	body := []byte(key.PublicKey)
+	if !armored {
+		if body, err = key.Data(); err != nil {
+			slog.Error("Error exporting keys", "error", err)
+			commonHttp.WriteErrorResponse(w, http.StatusInternalServerError, errors.ErrInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set(HeaderUpstreamSource, key.Source)
+	w.Header().Set(HeaderAuthoritative, "false")
+	if writeCacheHeaders(w, r, newETag(contentType, key.Source, key.PublicKey), key.FetchedAt) {
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Write(body)
+}
@@ -0,0 +1,162 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ggicci/httpin"
+	"github.com/hibare/DomainHQ/internal/config"
+	"github.com/hibare/DomainHQ/internal/mailer"
+	"github.com/hibare/DomainHQ/internal/models"
+	"github.com/hibare/DomainHQ/internal/verification"
+	"github.com/hibare/GoCommon/v2/pkg/errors"
+	commonHttp "github.com/hibare/GoCommon/v2/pkg/http"
+	"gorm.io/gorm"
+)
+
+// GPGVerifyParams carries the token of a mailed link to the confirmation page.
+type GPGVerifyParams struct {
+	Token string `in:"query=token;required"`
+}
+
+// GPGVerifyConfirmParams carries the token back from the confirmation form.
+type GPGVerifyConfirmParams struct {
+	Token string `in:"form=token;required"`
+}
+
+func verificationMessage(fingerprint, email, link string) mailer.Message {
+	body := &strings.Builder{}
+	fmt.Fprintf(body, "Someone uploaded the OpenPGP key %s with your address %s.\n\n", strings.ToUpper(fingerprint), email)
+	fmt.Fprintf(body, "To publish your address on this key, open the link below and confirm:\n\n%s\n\n", link)
+	fmt.Fprintf(body, "The link expires in %s. If you did not upload this key, ignore this message and the address stays unpublished.\n", config.Current.Verification.TokenTTL)
+
+	return mailer.Message{
+		To:      email,
+		Subject: "Verify your address for " + strings.ToUpper(fingerprint),
+		Body:    body.String(),
+	}
+}
+
+// sendVerificationEmails mails a verification link to each of the key's unverified addresses
+// and returns the addresses it mailed.
+func sendVerificationEmails(ctx context.Context, tx *gorm.DB, m mailer.Mailer, key *models.GPGPubKeyStore) ([]string, error) {
+	emails, err := models.UnverifiedEmails(tx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	sent := []string{}
+	for _, email := range emails {
+		ok, err := sendVerificationEmail(ctx, m, key.Fingerprint, email)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			sent = append(sent, email)
+		}
+	}
+	return sent, nil
+}
+
+// sendVerificationEmail mails a verification link for one address. Delivery failures are
+// logged and reported as not sent rather than failing the request.
+func sendVerificationEmail(ctx context.Context, m mailer.Mailer, fingerprint, email string) (bool, error) {
+	token, err := verification.NewToken(verification.PurposeVerify, fingerprint, email, config.Current.Verification.TokenTTL).Sign(config.Current.Verification.Secret)
+	if err != nil {
+		return false, err
+	}
+
+	link := fmt.Sprintf("%s/pks/verify?token=%s", config.Current.Server.PublicURL, url.QueryEscape(token))
+	if err := m.Send(ctx, verificationMessage(fingerprint, email, link)); err != nil {
+		slog.Error("Error sending verification email", "fingerprint", fingerprint, "email", email, "error", err)
+		return false, nil
+	}
+	return true, nil
+}
+
+var verifyTemplate = template.Must(template.New("verify").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Publish {{.Email}}</title>
+</head>
+<body>
+<h1>Publish {{.Email}}</h1>
+<p>Publish the address {{.Email}} on the OpenPGP key {{.Fingerprint}}?</p>
+<form method="post" action="{{.Action}}">
+<input type="hidden" name="token" value="{{.Token}}">
+<button type="submit">Publish</button>
+</form>
+</body>
+</html>
+`))
+
+// parseVerifyToken checks a verification link token, writing the error response if it is
+// not valid.
+func parseVerifyToken(w http.ResponseWriter, signed string) (*verification.Token, bool) {
+	if !config.Current.Verification.Enabled {
+		commonHttp.WriteErrorResponse(w, http.StatusNotFound, fmt.Errorf("verification is disabled"))
+		return nil, false
+	}
+
+	token, err := verification.Parse(config.Current.Verification.Secret, signed, verification.PurposeVerify)
+	if err != nil {
+		commonHttp.WriteErrorResponse(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+	return token, true
+}
+
+// GPGVerifyPage asks the owner to confirm publishing the address of a mailed link. Mail
+// scanners open links on their own, so following the link publishes nothing.
+func GPGVerifyPage(w http.ResponseWriter, r *http.Request) {
+	requestInput := r.Context().Value(httpin.Input).(*GPGVerifyParams)
+
+	token, ok := parseVerifyToken(w, requestInput.Token)
+	if !ok {
+		return
+	}
+
+	// The token is in the URL, keep it out of caches and Referer headers.
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("Content-Type", ContentTypeHTML)
+	w.WriteHeader(http.StatusOK)
+	err := verifyTemplate.Execute(w, struct {
+		Email       string
+		Fingerprint string
+		Token       string
+		Action      string
+	}{token.Email, strings.ToUpper(token.Fingerprint), requestInput.Token, config.Current.Server.PublicURL + "/pks/verify"})
+	if err != nil {
+		slog.Error("Error writing verification page", "error", err)
+	}
+}
+
+// GPGVerifyEmail publishes an address on a key once its owner confirms the mailed link.
+func GPGVerifyEmail(tx *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	requestInput := r.Context().Value(httpin.Input).(*GPGVerifyConfirmParams)
+
+	token, ok := parseVerifyToken(w, requestInput.Token)
+	if !ok {
+		return
+	}
+
+	if err := models.VerifyEmail(tx, token.Fingerprint, token.Email); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			commonHttp.WriteErrorResponse(w, http.StatusNotFound, fmt.Errorf("key not found"))
+			return
+		}
+
+		slog.Error("Error verifying email", "error", err)
+		commonHttp.WriteErrorResponse(w, http.StatusInternalServerError, errors.ErrInternalServerError)
+		return
+	}
+
+	commonHttp.WriteJSONResponse(w, http.StatusOK, "email verified")
+}
@@ -0,0 +1,264 @@
+package handler
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/ggicci/httpin"
+	"github.com/hibare/DomainHQ/internal/config"
+	"github.com/hibare/DomainHQ/internal/mailer"
+	"github.com/hibare/DomainHQ/internal/models"
+	"github.com/hibare/DomainHQ/internal/verification"
+	commonHttp "github.com/hibare/GoCommon/v2/pkg/http"
+	"gorm.io/gorm"
+)
+
+// Address states reported by the VKS upload and request-verify endpoints.
+const (
+	VKSStatusPublished   = "published"
+	VKSStatusUnpublished = "unpublished"
+	VKSStatusPending     = "pending"
+	VKSStatusRevoked     = "revoked"
+)
+
+var (
+	vksFingerprintPattern = regexp.MustCompile(`^(?i)([0-9a-f]{40}|[0-9a-f]{64})$`)
+	vksKeyIDPattern       = regexp.MustCompile(`^(?i)[0-9a-f]{16}$`)
+)
+
+type VKSLookupParams struct {
+	ID string `in:"path=id"`
+}
+
+type VKSUploadPayload struct {
+	KeyText string `json:"keytext"`
+}
+
+func (p *VKSUploadPayload) Validate() error {
+	if p == nil {
+		return errMissingBody
+	}
+	if strings.TrimSpace(p.KeyText) == "" {
+		return fmt.Errorf("keytext is required")
+	}
+	return nil
+}
+
+type VKSUploadParams struct {
+	Payload *VKSUploadPayload `in:"body=json"`
+}
+
+type VKSVerifyPayload struct {
+	Token     string   `json:"token"`
+	Addresses []string `json:"addresses"`
+	Locale    []string `json:"locale"`
+}
+
+func (p *VKSVerifyPayload) Validate() error {
+	if p == nil {
+		return errMissingBody
+	}
+	if p.Token == "" {
+		return fmt.Errorf("token is required")
+	}
+	return nil
+}
+
+type VKSVerifyParams struct {
+	Payload *VKSVerifyPayload `in:"body=json"`
+}
+
+// VKSUploadResponse is the body of both upload and request-verify. The token identifies the
+// upload in later request-verify calls.
+type VKSUploadResponse struct {
+	Token  string            `json:"token"`
+	KeyFpr string            `json:"key_fpr"`
+	Status map[string]string `json:"status"`
+}
+
+// writeVKSError writes an error in the {"error": "..."} shape VKS clients expect.
+func writeVKSError(w http.ResponseWriter, statusCode int, message string) {
+	commonHttp.WriteJSONResponse(w, statusCode, map[string]string{"error": message})
+}
+
+// vksStatus reports the publication state of every address on the key.
+func vksStatus(key *models.GPGPubKeyStore) map[string]string {
+	status := map[string]string{}
+	for _, user := range key.Users {
+		switch {
+		case user.Email == "":
+			continue
+		case user.Revoked:
+			status[user.Email] = VKSStatusRevoked
+		case key.IsPublished(user.Email):
+			status[user.Email] = VKSStatusPublished
+		default:
+			status[user.Email] = VKSStatusUnpublished
+		}
+	}
+	return status
+}
+
+func vksLookup(tx *gorm.DB, w http.ResponseWriter, r *http.Request, search string) {
+	keys, err := models.LookupPubKey(tx, search)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			writeVKSError(w, http.StatusNotFound, "no key found")
+			return
+		}
+
+		slog.Error("Error looking up key", "error", err)
+		writeVKSError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	writeKeyring(w, r, keys, ContentTypePGPKeys, true)
+}
+
+func VKSByFingerprint(tx *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	requestInput := r.Context().Value(httpin.Input).(*VKSLookupParams)
+
+	if !vksFingerprintPattern.MatchString(requestInput.ID) {
+		writeVKSError(w, http.StatusBadRequest, "invalid fingerprint")
+		return
+	}
+	vksLookup(tx, w, r, requestInput.ID)
+}
+
+func VKSByKeyID(tx *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	requestInput := r.Context().Value(httpin.Input).(*VKSLookupParams)
+
+	if !vksKeyIDPattern.MatchString(requestInput.ID) {
+		writeVKSError(w, http.StatusBadRequest, "invalid key id")
+		return
+	}
+	vksLookup(tx, w, r, requestInput.ID)
+}
+
+func VKSByEmail(tx *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	requestInput := r.Context().Value(httpin.Input).(*VKSLookupParams)
+
+	email, err := url.PathUnescape(requestInput.ID)
+	if err != nil || !strings.Contains(email, "@") {
+		writeVKSError(w, http.StatusBadRequest, "invalid email address")
+		return
+	}
+	vksLookup(tx, w, r, email)
+}
+
+// VKSUpload stores a single key and returns a token for requesting verification of its
+// addresses. Unlike /pks/add it does not mail anyone until request-verify is called.
+func VKSUpload(tx *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	requestInput := r.Context().Value(httpin.Input).(*VKSUploadParams)
+
+	if err := requestInput.Payload.Validate(); err != nil {
+		writeVKSError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	parsedKeys, err := models.ParsePubKeys(requestInput.Payload.KeyText)
+	if err != nil {
+		writeVKSError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(parsedKeys) != 1 {
+		writeVKSError(w, http.StatusBadRequest, "expected a single key")
+		return
+	}
+
+	results, err := models.ImportPubKeys(tx, parsedKeys)
+	if err != nil {
+		slog.Error("Error adding key", "error", err)
+		writeVKSError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	if results[0].Status == models.KeyRejected {
+		writeVKSError(w, http.StatusBadRequest, results[0].Reason)
+		return
+	}
+
+	key, ok := vksStoredKey(tx, w, results[0].Fingerprint)
+	if !ok {
+		return
+	}
+
+	token, err := verification.NewToken(verification.PurposeUpload, key.Fingerprint, "", config.Current.Verification.TokenTTL).Sign(config.Current.Verification.Secret)
+	if err != nil {
+		slog.Error("Error signing upload token", "error", err)
+		writeVKSError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	commonHttp.WriteJSONResponse(w, http.StatusOK, VKSUploadResponse{
+		Token:  token,
+		KeyFpr: strings.ToUpper(key.Fingerprint),
+		Status: vksStatus(key),
+	})
+}
+
+// VKSRequestVerify mails verification links for the requested addresses of an uploaded key.
+func VKSRequestVerify(tx *gorm.DB, m mailer.Mailer, w http.ResponseWriter, r *http.Request) {
+	requestInput := r.Context().Value(httpin.Input).(*VKSVerifyParams)
+
+	if err := requestInput.Payload.Validate(); err != nil {
+		writeVKSError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	token, err := verification.Parse(config.Current.Verification.Secret, requestInput.Payload.Token, verification.PurposeUpload)
+	if err != nil {
+		writeVKSError(w, http.StatusBadRequest, "invalid token")
+		return
+	}
+
+	key, ok := vksStoredKey(tx, w, token.Fingerprint)
+	if !ok {
+		return
+	}
+
+	status := vksStatus(key)
+	for _, address := range requestInput.Payload.Addresses {
+		address = strings.ToLower(strings.TrimSpace(address))
+		switch status[address] {
+		case "":
+			writeVKSError(w, http.StatusBadRequest, fmt.Sprintf("address %s is not on this key", address))
+			return
+		case VKSStatusUnpublished:
+			sent, err := sendVerificationEmail(r.Context(), m, key.Fingerprint, address)
+			if err != nil {
+				slog.Error("Error sending verification email", "error", err)
+				writeVKSError(w, http.StatusInternalServerError, "internal server error")
+				return
+			}
+			if sent {
+				status[address] = VKSStatusPending
+			}
+		}
+	}
+
+	commonHttp.WriteJSONResponse(w, http.StatusOK, VKSUploadResponse{
+		Token:  requestInput.Payload.Token,
+		KeyFpr: strings.ToUpper(key.Fingerprint),
+		Status: status,
+	})
+}
+
+// vksStoredKey loads a key by fingerprint, writing the error response when it fails.
+func vksStoredKey(tx *gorm.DB, w http.ResponseWriter, fingerprint string) (*models.GPGPubKeyStore, bool) {
+	keys, err := models.LookupPubKey(tx, fingerprint)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			writeVKSError(w, http.StatusNotFound, "no key found")
+			return nil, false
+		}
+
+		slog.Error("Error looking up key", "error", err)
+		writeVKSError(w, http.StatusInternalServerError, "internal server error")
+		return nil, false
+	}
+	return &keys[0], true
+}
@@ -1,60 +1,229 @@
 package handler
 
 import (
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/ggicci/httpin"
 	"github.com/hibare/DomainHQ/internal/config"
+	"github.com/hibare/DomainHQ/internal/models"
+	"github.com/hibare/DomainHQ/internal/resolver"
+	"github.com/hibare/GoCommon/v2/pkg/errors"
 	commonHttp "github.com/hibare/GoCommon/v2/pkg/http"
+	"gorm.io/gorm"
 )
 
 const REL = "http://openid.net/specs/connect/1.0/issuer"
 
+const (
+	ContentTypeJRD  = "application/jrd+json"
+	ContentTypeJSON = "application/json"
+	ContentTypeXRD  = "application/xrd+xml"
+	ContentTypeXML  = "application/xml"
+)
+
 type WebFingerParams struct {
-	Resource string `in:"query=resource;required"`
+	Resource string   `in:"query=resource;required"`
+	Rel      []string `in:"query=rel"`
 }
 
 type Link struct {
-	Rel  string `json:"rel"`
-	Href string `json:"href"`
+	Rel        string             `json:"rel"`
+	Type       string             `json:"type,omitempty"`
+	Href       string             `json:"href,omitempty"`
+	Titles     map[string]string  `json:"titles,omitempty"`
+	Properties map[string]*string `json:"properties,omitempty"`
+	Template   string             `json:"template,omitempty"`
 }
 
 type WebFingerResponse struct {
-	Subject string `json:"subject"`
-	Links   []Link `json:"links"`
+	Subject    string             `json:"subject,omitempty"`
+	Aliases    []string           `json:"aliases,omitempty"`
+	Properties map[string]*string `json:"properties,omitempty"`
+	Links      []Link             `json:"links"`
+}
+
+func newWebFingerResponse(account *models.WebFingerAccount, domain *config.WebFingerDomain) *WebFingerResponse {
+	resp := &WebFingerResponse{
+		Subject:    account.Subject,
+		Properties: account.Properties,
+		Links:      []Link{},
+	}
+	if len(account.Aliases) > 0 {
+		resp.Aliases = account.AliasURIs()
+	}
+
+	rels := map[string]bool{}
+	for _, link := range account.Links {
+		rels[link.Rel] = true
+		resp.Links = append(resp.Links, Link{
+			Rel:        link.Rel,
+			Type:       link.Type,
+			Href:       link.Href,
+			Titles:     link.Titles,
+			Properties: link.Properties,
+			Template:   link.Template,
+		})
+	}
+
+	// Accounts inherit the domain issuer and links unless they override the rel.
+	for _, link := range domain.Links {
+		if !rels[link.Rel] {
+			resp.Links = append(resp.Links, Link{Rel: link.Rel, Href: link.Href})
+		}
+	}
+	if !rels[REL] {
+		resp.Links = append([]Link{{Rel: REL, Href: domain.Resource}}, resp.Links...)
+	}
+
+	return resp
+}
+
+// FilterRels keeps only the links matching one of the given rels (RFC 7033 section 4.3).
+// Subject, aliases and properties are never filtered.
+func (resp *WebFingerResponse) FilterRels(rels []string) {
+	if len(rels) == 0 {
+		return
+	}
+
+	links := []Link{}
+	for _, link := range resp.Links {
+		if slices.Contains(rels, link.Rel) {
+			links = append(links, link)
+		}
+	}
+	resp.Links = links
+}
+
+// encodeDescriptor encodes the response as JRD or XRD depending on the content type.
+func encodeDescriptor(resp *WebFingerResponse, contentType string) ([]byte, error) {
+	if contentType == ContentTypeXRD || contentType == ContentTypeXML {
+		return resp.XRD().Marshal()
+	}
+	return json.Marshal(resp)
 }
 
-func WebFinger(w http.ResponseWriter, r *http.Request) {
+// writeDescriptor writes the response as JRD or XRD depending on the content type.
+func writeDescriptor(w http.ResponseWriter, r *http.Request, resp *WebFingerResponse, contentType string, lastModified time.Time) {
+	body, err := encodeDescriptor(resp, contentType)
+	if err != nil {
+		slog.Error("Error encoding response", "error", err)
+		commonHttp.WriteErrorResponse(w, http.StatusInternalServerError, errors.ErrInternalServerError)
+		return
+	}
+
+	w.Header().Add("Vary", "Accept")
+	if writeCacheHeaders(w, r, newETag(string(body)), lastModified) {
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// splitAccount splits "user@domain" into its local and domain parts.
+func splitAccount(account string) (string, string, bool) {
+	at := strings.LastIndex(account, "@")
+	if at <= 0 || at == len(account)-1 {
+		return "", "", false
+	}
+	return account[:at], strings.ToLower(account[at+1:]), true
+}
+
+// resolveSubject maps a WebFinger resource onto its canonical acct: subject and the stored
+// account, if there is one. acct: and mailto: URIs map directly, every other scheme is
+// matched on the account aliases.
+func resolveSubject(tx *gorm.DB, resource string) (string, *models.WebFingerAccount, int, error) {
+	u, err := url.Parse(resource)
+	if err != nil || u.Scheme == "" {
+		return "", nil, http.StatusBadRequest, fmt.Errorf("invalid 'resource' parameter")
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	if !slices.Contains(config.Current.WebFinger.Schemes, scheme) {
+		return "", nil, http.StatusBadRequest, fmt.Errorf("unsupported 'resource' scheme")
+	}
+
+	var account *models.WebFingerAccount
+	switch scheme {
+	case "acct", "mailto":
+		local, domain, ok := splitAccount(u.Opaque)
+		if !ok {
+			return "", nil, http.StatusBadRequest, fmt.Errorf("invalid 'resource' parameter")
+		}
+
+		if _, ok := config.Current.WebFinger.LookupDomain(domain); !ok {
+			slog.Warn("Resource does not match any domain", "resource", resource, "domains", config.Current.WebFinger.DomainNames())
+			return "", nil, http.StatusForbidden, fmt.Errorf("domain not allowed")
+		}
+
+		subject := strings.ToLower(fmt.Sprintf("acct:%s@%s", local, domain))
+		account, err = models.GetWebFingerAccount(tx, subject)
+		if err == gorm.ErrRecordNotFound {
+			return subject, nil, http.StatusOK, nil
+		}
+	default:
+		account, err = models.GetWebFingerAccountByAlias(tx, resource)
+	}
+
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return "", nil, http.StatusNotFound, fmt.Errorf("account not found")
+		}
+
+		slog.Error("Error looking up account", "error", err)
+		return "", nil, http.StatusInternalServerError, errors.ErrInternalServerError
+	}
+
+	return account.Subject, account, http.StatusOK, nil
+}
+
+func WebFinger(tx *gorm.DB, accounts resolver.AccountResolver, w http.ResponseWriter, r *http.Request) {
 	requestInput := r.Context().Value(httpin.Input).(*WebFingerParams)
 
 	resource := requestInput.Resource
-	parts := strings.SplitN(resource, ":", 2)
-	if len(parts) != 2 || parts[0] != "acct" {
-		commonHttp.WriteErrorResponse(w, http.StatusBadRequest, fmt.Errorf("invalid 'resource' parameter"))
+	subject, account, status, err := resolveSubject(tx, resource)
+	if err != nil {
+		commonHttp.WriteErrorResponse(w, status, err)
 		return
 	}
 
-	if !strings.HasSuffix(parts[1], fmt.Sprintf("@%s", config.Current.WebFinger.Domain)) {
-		slog.Warn("Resource does not match domain", "resource", resource, "domain", config.Current.WebFinger.Domain)
-		commonHttp.WriteErrorResponse(w, http.StatusForbidden, fmt.Errorf("domain not allowed"))
+	exists, err := accounts.Exists(r.Context(), subject)
+	if err != nil {
+		slog.Error("Error resolving account", "subject", subject, "error", err)
+		commonHttp.WriteErrorResponse(w, http.StatusInternalServerError, errors.ErrInternalServerError)
 		return
 	}
 
-	// ToDo: Validate account with IDP
+	// Unknown accounts get the same 404 no matter the backend so they can't be enumerated.
+	if !exists {
+		commonHttp.WriteErrorResponse(w, http.StatusNotFound, fmt.Errorf("account not found"))
+		return
+	}
 
-	resp := &WebFingerResponse{
-		Subject: resource,
-		Links: []Link{
-			{
-				Rel:  REL,
-				Href: config.Current.WebFinger.Resource,
-			},
-		},
+	if account == nil {
+		account = &models.WebFingerAccount{Subject: subject}
+	}
+
+	_, domainName, _ := splitAccount(strings.TrimPrefix(account.Subject, "acct:"))
+	domain, ok := config.Current.WebFinger.LookupDomain(domainName)
+	if !ok {
+		slog.Warn("Account does not match any domain", "resource", resource, "subject", account.Subject)
+		commonHttp.WriteErrorResponse(w, http.StatusForbidden, fmt.Errorf("domain not allowed"))
+		return
 	}
+
+	resp := newWebFingerResponse(account, domain)
+	resp.FilterRels(requestInput.Rel)
 	slog.Info("Resource allowed", "resource", resource)
 
-	commonHttp.WriteJSONResponse(w, http.StatusOK, resp)
+	contentType := negotiateContentType(r, ContentTypeJRD, ContentTypeJSON, ContentTypeXRD, ContentTypeXML)
+	writeDescriptor(w, r, resp, contentType, account.UpdatedAt)
 }
@@ -0,0 +1,329 @@
+package handler
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ggicci/httpin"
+	"github.com/hibare/DomainHQ/internal/config"
+	"github.com/hibare/DomainHQ/internal/models"
+	"github.com/hibare/GoCommon/v2/pkg/errors"
+	commonHttp "github.com/hibare/GoCommon/v2/pkg/http"
+	"gorm.io/gorm"
+)
+
+// errMissingBody is returned by Validate for a JSON body of null, which decodes to a nil payload.
+var errMissingBody = fmt.Errorf("request body is required")
+
+type WebFingerLinkPayload struct {
+	Rel        string             `json:"rel"`
+	Type       string             `json:"type"`
+	Href       string             `json:"href"`
+	Titles     map[string]string  `json:"titles"`
+	Properties map[string]*string `json:"properties"`
+	Template   string             `json:"template"`
+}
+
+func (p *WebFingerLinkPayload) Validate() error {
+	if p == nil {
+		return errMissingBody
+	}
+	if strings.TrimSpace(p.Rel) == "" {
+		return fmt.Errorf("link 'rel' is required")
+	}
+	if p.Href != "" && !isAbsoluteURI(p.Href) {
+		return fmt.Errorf("link 'href' must be an absolute URI")
+	}
+	return nil
+}
+
+func (p *WebFingerLinkPayload) model(accountID uint) models.WebFingerLink {
+	return models.WebFingerLink{
+		AccountID:  accountID,
+		Rel:        p.Rel,
+		Type:       p.Type,
+		Href:       p.Href,
+		Titles:     p.Titles,
+		Properties: p.Properties,
+		Template:   p.Template,
+	}
+}
+
+type WebFingerAliasPayload struct {
+	URI string `json:"uri"`
+}
+
+func (p *WebFingerAliasPayload) Validate() error {
+	if p == nil {
+		return errMissingBody
+	}
+	if !isAbsoluteURI(p.URI) {
+		return fmt.Errorf("alias must be an absolute URI")
+	}
+	return nil
+}
+
+type WebFingerAccountPayload struct {
+	Subject    string                 `json:"subject"`
+	Aliases    []string               `json:"aliases"`
+	Properties map[string]*string     `json:"properties"`
+	Links      []WebFingerLinkPayload `json:"links"`
+}
+
+func (p *WebFingerAccountPayload) Validate() error {
+	if p == nil {
+		return errMissingBody
+	}
+	local, ok := strings.CutPrefix(strings.ToLower(p.Subject), "acct:")
+	if !ok {
+		return fmt.Errorf("subject must be an acct: URI")
+	}
+
+	_, domain, ok := splitAccount(local)
+	if !ok {
+		return fmt.Errorf("subject must be of the form acct:user@domain")
+	}
+	if _, ok := config.Current.WebFinger.LookupDomain(domain); !ok {
+		return fmt.Errorf("domain not allowed")
+	}
+
+	for _, alias := range p.Aliases {
+		if !isAbsoluteURI(alias) {
+			return fmt.Errorf("alias must be an absolute URI")
+		}
+	}
+
+	for i := range p.Links {
+		if err := p.Links[i].Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *WebFingerAccountPayload) model(id uint) *models.WebFingerAccount {
+	account := &models.WebFingerAccount{
+		ID:         id,
+		Subject:    p.Subject,
+		Properties: p.Properties,
+	}
+	for _, alias := range p.Aliases {
+		account.Aliases = append(account.Aliases, models.WebFingerAlias{AccountID: id, URI: alias})
+	}
+	for i := range p.Links {
+		account.Links = append(account.Links, p.Links[i].model(id))
+	}
+	return account
+}
+
+type WebFingerAccountListParams struct {
+	Domain string `in:"query=domain"`
+}
+
+type WebFingerAccountParams struct {
+	ID uint `in:"path=id"`
+}
+
+type WebFingerAccountCreateParams struct {
+	Payload *WebFingerAccountPayload `in:"body=json"`
+}
+
+type WebFingerAccountUpdateParams struct {
+	ID      uint                     `in:"path=id"`
+	Payload *WebFingerAccountPayload `in:"body=json"`
+}
+
+type WebFingerAliasCreateParams struct {
+	ID      uint                   `in:"path=id"`
+	Payload *WebFingerAliasPayload `in:"body=json"`
+}
+
+type WebFingerAliasParams struct {
+	ID      uint `in:"path=id"`
+	AliasID uint `in:"path=alias_id"`
+}
+
+type WebFingerLinkCreateParams struct {
+	ID      uint                  `in:"path=id"`
+	Payload *WebFingerLinkPayload `in:"body=json"`
+}
+
+type WebFingerLinkUpdateParams struct {
+	ID      uint                  `in:"path=id"`
+	LinkID  uint                  `in:"path=link_id"`
+	Payload *WebFingerLinkPayload `in:"body=json"`
+}
+
+type WebFingerLinkParams struct {
+	ID     uint `in:"path=id"`
+	LinkID uint `in:"path=link_id"`
+}
+
+func isAbsoluteURI(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.Scheme != ""
+}
+
+// writeStoreError maps an error from the models onto an HTTP error response.
+func writeStoreError(w http.ResponseWriter, err error, notFound string) {
+	switch err {
+	case gorm.ErrRecordNotFound:
+		commonHttp.WriteErrorResponse(w, http.StatusNotFound, fmt.Errorf("%s", notFound))
+	case gorm.ErrDuplicatedKey:
+		commonHttp.WriteErrorResponse(w, http.StatusConflict, fmt.Errorf("already exists"))
+	default:
+		slog.Error("Error accessing store", "error", err)
+		commonHttp.WriteErrorResponse(w, http.StatusInternalServerError, errors.ErrInternalServerError)
+	}
+}
+
+func ListWebFingerAccounts(tx *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	requestInput := r.Context().Value(httpin.Input).(*WebFingerAccountListParams)
+
+	accounts, err := models.ListWebFingerAccounts(tx, requestInput.Domain)
+	if err != nil {
+		writeStoreError(w, err, "account not found")
+		return
+	}
+
+	commonHttp.WriteJSONResponse(w, http.StatusOK, accounts)
+}
+
+func GetWebFingerAccount(tx *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	requestInput := r.Context().Value(httpin.Input).(*WebFingerAccountParams)
+
+	account, err := models.GetWebFingerAccountByID(tx, requestInput.ID)
+	if err != nil {
+		writeStoreError(w, err, "account not found")
+		return
+	}
+
+	commonHttp.WriteJSONResponse(w, http.StatusOK, account)
+}
+
+func CreateWebFingerAccount(tx *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	requestInput := r.Context().Value(httpin.Input).(*WebFingerAccountCreateParams)
+
+	if err := requestInput.Payload.Validate(); err != nil {
+		commonHttp.WriteErrorResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	account := requestInput.Payload.model(0)
+	if err := models.CreateWebFingerAccount(tx, account); err != nil {
+		writeStoreError(w, err, "account not found")
+		return
+	}
+
+	commonHttp.WriteJSONResponse(w, http.StatusCreated, account)
+}
+
+func UpdateWebFingerAccount(tx *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	requestInput := r.Context().Value(httpin.Input).(*WebFingerAccountUpdateParams)
+
+	if err := requestInput.Payload.Validate(); err != nil {
+		commonHttp.WriteErrorResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := models.UpdateWebFingerAccount(tx, requestInput.Payload.model(requestInput.ID)); err != nil {
+		writeStoreError(w, err, "account not found")
+		return
+	}
+
+	account, err := models.GetWebFingerAccountByID(tx, requestInput.ID)
+	if err != nil {
+		writeStoreError(w, err, "account not found")
+		return
+	}
+
+	commonHttp.WriteJSONResponse(w, http.StatusOK, account)
+}
+
+func DeleteWebFingerAccount(tx *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	requestInput := r.Context().Value(httpin.Input).(*WebFingerAccountParams)
+
+	if err := models.DeleteWebFingerAccount(tx, requestInput.ID); err != nil {
+		writeStoreError(w, err, "account not found")
+		return
+	}
+
+	commonHttp.WriteJSONResponse(w, http.StatusOK, "account deleted")
+}
+
+func AddWebFingerAlias(tx *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	requestInput := r.Context().Value(httpin.Input).(*WebFingerAliasCreateParams)
+
+	if err := requestInput.Payload.Validate(); err != nil {
+		commonHttp.WriteErrorResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	alias := &models.WebFingerAlias{AccountID: requestInput.ID, URI: requestInput.Payload.URI}
+	if err := models.AddWebFingerAlias(tx, alias); err != nil {
+		writeStoreError(w, err, "account not found")
+		return
+	}
+
+	commonHttp.WriteJSONResponse(w, http.StatusCreated, alias)
+}
+
+func DeleteWebFingerAlias(tx *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	requestInput := r.Context().Value(httpin.Input).(*WebFingerAliasParams)
+
+	if err := models.DeleteWebFingerAlias(tx, requestInput.ID, requestInput.AliasID); err != nil {
+		writeStoreError(w, err, "alias not found")
+		return
+	}
+
+	commonHttp.WriteJSONResponse(w, http.StatusOK, "alias deleted")
+}
+
+func AddWebFingerLink(tx *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	requestInput := r.Context().Value(httpin.Input).(*WebFingerLinkCreateParams)
+
+	if err := requestInput.Payload.Validate(); err != nil {
+		commonHttp.WriteErrorResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	link := requestInput.Payload.model(requestInput.ID)
+	if err := models.AddWebFingerLink(tx, &link); err != nil {
+		writeStoreError(w, err, "account not found")
+		return
+	}
+
+	commonHttp.WriteJSONResponse(w, http.StatusCreated, link)
+}
+
+func UpdateWebFingerLink(tx *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	requestInput := r.Context().Value(httpin.Input).(*WebFingerLinkUpdateParams)
+
+	if err := requestInput.Payload.Validate(); err != nil {
+		commonHttp.WriteErrorResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	link := requestInput.Payload.model(requestInput.ID)
+	link.ID = requestInput.LinkID
+	if err := models.UpdateWebFingerLink(tx, &link); err != nil {
+		writeStoreError(w, err, "link not found")
+		return
+	}
+
+	commonHttp.WriteJSONResponse(w, http.StatusOK, link)
+}
+
+func DeleteWebFingerLink(tx *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	requestInput := r.Context().Value(httpin.Input).(*WebFingerLinkParams)
+
+	if err := models.DeleteWebFingerLink(tx, requestInput.ID, requestInput.LinkID); err != nil {
+		writeStoreError(w, err, "link not found")
+		return
+	}
+
+	commonHttp.WriteJSONResponse(w, http.StatusOK, "link deleted")
+}
@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/ggicci/httpin"
+	"github.com/hibare/DomainHQ/internal/config"
+	"github.com/hibare/DomainHQ/internal/models"
+	"github.com/hibare/GoCommon/v2/pkg/errors"
+	commonHttp "github.com/hibare/GoCommon/v2/pkg/http"
+	"gorm.io/gorm"
+)
+
+const ContentTypeOctetStream = "application/octet-stream"
+
+type WKDParams struct {
+	Domain string `in:"path=domain"`
+	Hash   string `in:"path=hash"`
+	Local  string `in:"query=l"`
+}
+
+// wkdDomain returns the domain a WKD request is for. The advanced method names it in the
+// path, the direct method is served from the domain itself.
+func wkdDomain(r *http.Request, requestInput *WKDParams) (string, bool) {
+	domain := requestInput.Domain
+	if domain == "" {
+		domain = r.Host
+		if host, _, err := net.SplitHostPort(r.Host); err == nil {
+			domain = host
+		}
+	}
+
+	d, ok := config.Current.WebFinger.LookupDomain(domain)
+	if !ok {
+		return "", false
+	}
+	return d.Name, true
+}
+
+// WKDPolicy serves the, intentionally empty, policy file that marks WKD support.
+func WKDPolicy(w http.ResponseWriter, r *http.Request) {
+	requestInput := r.Context().Value(httpin.Input).(*WKDParams)
+
+	if _, ok := wkdDomain(r, requestInput); !ok {
+		commonHttp.WriteErrorResponse(w, http.StatusNotFound, fmt.Errorf("domain not found"))
+		return
+	}
+
+	w.Header().Set("Content-Type", ContentTypeText)
+	w.WriteHeader(http.StatusOK)
+}
+
+// WKDLookup serves the keys of an address, keeping only the user IDs of that address.
+func WKDLookup(tx *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	requestInput := r.Context().Value(httpin.Input).(*WKDParams)
+
+	domain, ok := wkdDomain(r, requestInput)
+	if !ok {
+		commonHttp.WriteErrorResponse(w, http.StatusNotFound, fmt.Errorf("domain not found"))
+		return
+	}
+
+	hash := strings.ToLower(requestInput.Hash)
+	if requestInput.Local != "" && models.WKDHash(requestInput.Local) != hash {
+		commonHttp.WriteErrorResponse(w, http.StatusNotFound, fmt.Errorf("key not found"))
+		return
+	}
+
+	keys, err := models.ListPubKeysByWKDHash(tx, domain, hash)
+	if err != nil {
+		slog.Error("Error looking up key", "error", err)
+		commonHttp.WriteErrorResponse(w, http.StatusInternalServerError, errors.ErrInternalServerError)
+		return
+	}
+
+	body := &bytes.Buffer{}
+	etagParts := []string{}
+	lastModified := time.Time{}
+	for i := range keys {
+		data, kept, err := keys[i].ExportWithUIDs(func(uid *packet.UserId) bool {
+			local, uidDomain, ok := splitAccount(uid.Email)
+			return ok && uidDomain == domain && models.WKDHash(local) == hash && keys[i].IsPublished(uid.Email)
+		})
+		if err != nil {
+			slog.Error("Error exporting key", "fingerprint", keys[i].Fingerprint, "error", err)
+			commonHttp.WriteErrorResponse(w, http.StatusInternalServerError, errors.ErrInternalServerError)
+			return
+		}
+		if kept == 0 {
+			continue
+		}
+
+		body.Write(data)
+		etagParts = append(etagParts, keys[i].Fingerprint, keys[i].UpdatedAt.UTC().Format(time.RFC3339Nano))
+		if keys[i].UpdatedAt.After(lastModified) {
+			lastModified = keys[i].UpdatedAt
+		}
+	}
+
+	if body.Len() == 0 {
+		commonHttp.WriteErrorResponse(w, http.StatusNotFound, fmt.Errorf("key not found"))
+		return
+	}
+	if writeCacheHeaders(w, r, newETag(etagParts...), lastModified) {
+		return
+	}
+
+	w.Header().Set("Content-Type", ContentTypeOctetStream)
+	w.Write(body.Bytes())
+}
@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"encoding/xml"
+	"slices"
+	"strings"
+)
+
+const (
+	XRDNamespace = "http://docs.oasis-open.org/ns/xri/xrd-1.0"
+	XSINamespace = "http://www.w3.org/2001/XMLSchema-instance"
+
+	// Titles without a language tag use "und" in JRD (RFC 7033 section 4.4.4.4).
+	undeterminedLanguage = "und"
+)
+
+type XRDProperty struct {
+	Type  string `xml:"type,attr"`
+	Nil   string `xml:"xsi:nil,attr,omitempty"`
+	Value string `xml:",chardata"`
+}
+
+type XRDTitle struct {
+	Lang  string `xml:"xml:lang,attr,omitempty"`
+	Value string `xml:",chardata"`
+}
+
+type XRDLink struct {
+	Rel        string        `xml:"rel,attr"`
+	Type       string        `xml:"type,attr,omitempty"`
+	Href       string        `xml:"href,attr,omitempty"`
+	Template   string        `xml:"template,attr,omitempty"`
+	Titles     []XRDTitle    `xml:"Title"`
+	Properties []XRDProperty `xml:"Property"`
+}
+
+// XRD is the XML representation of a JRD (RFC 6415 appendix A).
+type XRD struct {
+	XMLName    xml.Name      `xml:"XRD"`
+	XMLNS      string        `xml:"xmlns,attr"`
+	XMLNSXSI   string        `xml:"xmlns:xsi,attr,omitempty"`
+	Subject    string        `xml:"Subject,omitempty"`
+	Aliases    []string      `xml:"Alias"`
+	Properties []XRDProperty `xml:"Property"`
+	Links      []XRDLink     `xml:"Link"`
+}
+
+func (x *XRD) properties(props map[string]*string) []XRDProperty {
+	types := make([]string, 0, len(props))
+	for t := range props {
+		types = append(types, t)
+	}
+	slices.Sort(types)
+
+	properties := []XRDProperty{}
+	for _, t := range types {
+		if props[t] == nil {
+			x.XMLNSXSI = XSINamespace
+			properties = append(properties, XRDProperty{Type: t, Nil: "true"})
+			continue
+		}
+		properties = append(properties, XRDProperty{Type: t, Value: *props[t]})
+	}
+	return properties
+}
+
+// XRD converts the JRD into its XRD form.
+func (resp *WebFingerResponse) XRD() *XRD {
+	x := &XRD{
+		XMLNS:   XRDNamespace,
+		Subject: resp.Subject,
+		Aliases: resp.Aliases,
+	}
+	x.Properties = x.properties(resp.Properties)
+
+	for _, link := range resp.Links {
+		langs := make([]string, 0, len(link.Titles))
+		for lang := range link.Titles {
+			langs = append(langs, lang)
+		}
+		slices.Sort(langs)
+
+		titles := []XRDTitle{}
+		for _, lang := range langs {
+			title := XRDTitle{Value: link.Titles[lang]}
+			if !strings.EqualFold(lang, undeterminedLanguage) {
+				title.Lang = lang
+			}
+			titles = append(titles, title)
+		}
+
+		x.Links = append(x.Links, XRDLink{
+			Rel:        link.Rel,
+			Type:       link.Type,
+			Href:       link.Href,
+			Template:   link.Template,
+			Titles:     titles,
+			Properties: x.properties(link.Properties),
+		})
+	}
+
+	return x
+}
+
+func (x *XRD) Marshal() ([]byte, error) {
+	body, err := xml.MarshalIndent(x, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
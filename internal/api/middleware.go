@@ -0,0 +1,137 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	commonHttp "github.com/hibare/GoCommon/v2/pkg/http"
+)
+
+// PublicCORS applies the CORS policy of the public discovery endpoints. Only GET and HEAD
+// are allowed cross-origin and the Authorization header is never allowed, so the
+// authenticated routes stay out of reach of browsers.
+func PublicCORS(next http.Handler, allowedOrigins []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+
+		switch {
+		case slices.Contains(allowedOrigins, "*"):
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		case origin != "" && slices.Contains(allowedOrigins, origin):
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+		}
+
+		// Answer preflight requests directly, they never reach a route.
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Accept, If-None-Match, If-Modified-Since")
+			w.Header().Set("Access-Control-Max-Age", "86400")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.Header().Set("Access-Control-Expose-Headers", "ETag, Last-Modified, X-Upstream-Source, X-Authoritative")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RealIP replaces the remote address with the client address forwarded by a trusted proxy in
+// X-Forwarded-For or X-Real-IP. The headers of other clients are ignored, since they could
+// otherwise pick a fresh address for every request and never run into RateLimit.
+func RealIP(trusted []netip.Prefix) func(http.Handler) http.Handler {
+	isTrusted := func(addr netip.Addr) bool {
+		return slices.ContainsFunc(trusted, func(p netip.Prefix) bool { return p.Contains(addr.Unmap()) })
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			peer, err := netip.ParseAddrPort(r.RemoteAddr)
+			if err != nil || !isTrusted(peer.Addr()) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Every proxy appends the address it got the request from, so the client is the
+			// last hop not in a trusted network. Anything left of it is up to the client.
+			client := ""
+			if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+				hops := strings.Split(strings.Join(forwarded, ","), ",")
+				for i := len(hops) - 1; i >= 0; i-- {
+					addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+					if err != nil {
+						break
+					}
+					client = addr.Unmap().String()
+					if !isTrusted(addr) {
+						break
+					}
+				}
+			} else if addr, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+				client = addr.Unmap().String()
+			}
+
+			if client != "" {
+				r.RemoteAddr = client
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimiter counts requests per client in fixed windows. All counts are dropped when a
+// window ends, so memory is bounded by the clients seen in one window.
+type rateLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	start  time.Time
+	counts map[string]int
+	now    func() time.Time
+}
+
+// allow records a request from client and reports whether it is within the limit, and
+// otherwise how long until the window ends.
+func (l *rateLimiter) allow(client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.start) >= l.window {
+		l.start = now
+		l.counts = map[string]int{}
+	}
+	l.counts[client]++
+	return l.counts[client] <= l.limit, l.start.Add(l.window).Sub(now)
+}
+
+// RateLimit allows each client, identified by its remote IP, limit requests per window and
+// answers the rest with 429 Too Many Requests. Behind a proxy, RealIP must run first and
+// trust it.
+func RateLimit(limit int, window time.Duration) func(http.Handler) http.Handler {
+	l := &rateLimiter{limit: limit, window: window, counts: map[string]int{}, now: time.Now}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				client = r.RemoteAddr
+			}
+
+			ok, retryAfter := l.allow(client)
+			if !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Round(time.Second).Seconds())))
+				commonHttp.WriteErrorResponse(w, http.StatusTooManyRequests, fmt.Errorf("too many requests"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/hibare/DomainHQ/internal/config"
+	"github.com/hibare/DomainHQ/internal/models"
+)
+
+// StartScheduler runs the periodic maintenance jobs in the background until ctx is done.
+func (a *App) StartScheduler(ctx context.Context) {
+	go func() {
+		// The refresh saves keys as it read them, so it would write back removed ones.
+		a.enforceUIDPolicy()
+		runEvery(ctx, config.Current.GPG.StatusRefreshInterval, a.refreshKeyStatus)
+	}()
+	if config.Current.Upstream.Enabled() {
+		// Misses expire sooner than keys, and anyone can add them with a new search.
+		go runEvery(ctx, min(config.Current.Upstream.CacheTTL, config.Current.Upstream.MissTTL), a.purgeUpstreamKeys)
+	}
+	if a.Replication != nil {
+		go runEvery(ctx, config.Current.Replication.Interval, func() {
+			a.Replication.Sync(ctx)
+		})
+	}
+}
+
+// runEvery runs job right away and then once per interval.
+func runEvery(ctx context.Context, interval time.Duration, job func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		job()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *App) refreshKeyStatus() {
+	changed, err := models.RefreshKeyStatus(a.DB, time.Now())
+	if err != nil {
+		slog.Error("failed to refresh key status", "error", err)
+		return
+	}
+	slog.Debug("Refreshed key status", "changed", changed)
+}
+
+// enforceUIDPolicy runs once at startup, since the policy only changes with the configuration.
+func (a *App) enforceUIDPolicy() {
+	changed, err := models.EnforceUIDPolicy(a.DB)
+	if err != nil {
+		slog.Error("failed to enforce UID policy", "error", err)
+		return
+	}
+	slog.Debug("Enforced UID policy", "changed", changed)
+}
+
+func (a *App) purgeUpstreamKeys() {
+	purged, err := models.PurgeUpstreamKeys(a.DB, time.Now())
+	if err != nil {
+		slog.Error("failed to purge upstream keys", "error", err)
+		return
+	}
+	slog.Debug("Purged upstream keys", "purged", purged)
+}
@@ -15,21 +15,60 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/hibare/DomainHQ/internal/api/handler"
 	"github.com/hibare/DomainHQ/internal/config"
+	"github.com/hibare/DomainHQ/internal/mailer"
 	"github.com/hibare/DomainHQ/internal/models"
+	"github.com/hibare/DomainHQ/internal/replication"
+	"github.com/hibare/DomainHQ/internal/resolver"
+	"github.com/hibare/DomainHQ/internal/upstream"
 	commonHandler "github.com/hibare/GoCommon/v2/pkg/http/handler"
 	commonMiddleware "github.com/hibare/GoCommon/v2/pkg/http/middleware"
 	"gorm.io/gorm"
 )
 
 type App struct {
-	Router *chi.Mux
-	DB     *gorm.DB
+	Router   *chi.Mux
+	DB       *gorm.DB
+	Resolver resolver.AccountResolver
+	Mailer   mailer.Mailer
+	Upstream *upstream.Client
+	// Replication is nil unless replication peers are configured.
+	Replication *replication.Syncer
 }
 
 func home(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "Good to see you")
 }
 
+// withDB adapts a handler that needs the database to a http.HandlerFunc.
+func (a *App) withDB(h func(*gorm.DB, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h(a.DB, w, r)
+	}
+}
+
+func tokenAuth(h http.Handler) http.Handler {
+	return commonMiddleware.TokenAuth(h, config.Current.API.APIKeys)
+}
+
+// replicationAuth admits peers holding a replication token. The tokens only open the change
+// feed, so a peer cannot write to this instance.
+func replicationAuth(h http.Handler) http.Handler {
+	return commonMiddleware.TokenAuth(h, config.Current.Replication.Tokens)
+}
+
+// verificationOrTokenAuth lets anyone through while email verification gates what gets
+// published, and otherwise requires an API key.
+func verificationOrTokenAuth(h http.Handler) http.Handler {
+	authenticated := tokenAuth(h)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.Current.Verification.Enabled {
+			h.ServeHTTP(w, r)
+			return
+		}
+		authenticated.ServeHTTP(w, r)
+	})
+}
+
 func (a *App) Init() {
 	db, err := models.InitDB()
 	if err != nil {
@@ -37,28 +76,114 @@ func (a *App) Init() {
 	}
 	a.DB = db
 
+	accounts, err := resolver.New(config.Current.WebFinger.Resolver, a.DB)
+	if err != nil {
+		slog.Error("failed to initialize account resolver", "error", err)
+	}
+	a.Resolver = accounts
+
+	m, err := mailer.New(config.Current.Mailer)
+	if err != nil {
+		slog.Error("failed to initialize mailer", "error", err)
+	}
+	a.Mailer = m
+
+	if config.Current.Upstream.Enabled() {
+		keyservers, err := upstream.New(config.Current.Upstream)
+		if err != nil {
+			slog.Error("failed to initialize upstream keyservers", "error", err)
+		}
+		a.Upstream = keyservers
+	}
+
+	if config.Current.Replication.Enabled() {
+		a.Replication = replication.New(config.Current.Replication, a.DB)
+	}
+
 	a.Router = chi.NewRouter()
 	a.Router.Use(middleware.RequestID)
-	a.Router.Use(middleware.RealIP)
+	a.Router.Use(RealIP(config.Current.HTTP.TrustedProxies))
 	a.Router.Use(commonMiddleware.RequestLogger)
 	a.Router.Use(middleware.Recoverer)
 	a.Router.Use(middleware.Timeout(60 * time.Second))
 	a.Router.Use(middleware.StripSlashes)
 	a.Router.Use(middleware.CleanPath)
+	a.Router.Use(func(h http.Handler) http.Handler {
+		return PublicCORS(h, config.Current.HTTP.CORSAllowedOrigins)
+	})
 
 	a.Router.Get("/", home)
 	a.Router.Get("/ping", commonHandler.HealthCheck)
-	a.Router.With(httpin.NewInput(handler.WebFingerParams{})).Get("/.well-known/webfinger", handler.WebFinger)
+	a.Router.Get("/.well-known/host-meta", handler.HostMeta)
+	a.Router.Get("/.well-known/host-meta.json", handler.HostMetaJSON)
+	a.Router.With(httpin.NewInput(handler.WebFingerParams{})).Get("/.well-known/webfinger", func(w http.ResponseWriter, r *http.Request) {
+		handler.WebFinger(a.DB, a.Resolver, w, r)
+	})
+	a.Router.Route("/.well-known/openpgpkey", func(r chi.Router) {
+		r.With(httpin.NewInput(handler.WKDParams{})).Get("/policy", handler.WKDPolicy)
+		r.With(httpin.NewInput(handler.WKDParams{})).Get("/hu/{hash}", a.withDB(handler.WKDLookup))
+		r.With(httpin.NewInput(handler.WKDParams{})).Get("/{domain}/policy", handler.WKDPolicy)
+		r.With(httpin.NewInput(handler.WKDParams{})).Get("/{domain}/hu/{hash}", a.withDB(handler.WKDLookup))
+	})
 	a.Router.Route("/pks", func(r chi.Router) {
 		r.With(httpin.NewInput(handler.GPGLookupParams{})).Get("/lookup", func(w http.ResponseWriter, r *http.Request) {
-			handler.GPGPubKeyLookup(a.DB, w, r)
+			handler.GPGPubKeyLookup(a.DB, a.Upstream, w, r)
 		})
+		r.With(httpin.NewInput(handler.GPGVerifyParams{})).Get("/verify", handler.GPGVerifyPage)
+		r.With(httpin.NewInput(handler.GPGVerifyConfirmParams{})).Post("/verify", a.withDB(handler.GPGVerifyEmail))
 		r.Group(func(r chi.Router) {
-			r.Use(func(h http.Handler) http.Handler {
-				return commonMiddleware.TokenAuth(h, config.Current.API.APIKeys)
-			})
+			r.Use(tokenAuth)
 			r.With(httpin.NewInput(handler.GPGKeyAddParams{})).Post("/add", func(w http.ResponseWriter, r *http.Request) {
-				handler.GPGPubKeyAdd(a.DB, w, r)
+				handler.GPGPubKeyAdd(a.DB, a.Mailer, w, r)
+			})
+		})
+	})
+	a.Router.Route("/vks/v1", func(r chi.Router) {
+		r.With(httpin.NewInput(handler.VKSLookupParams{})).Get("/by-fingerprint/{id}", a.withDB(handler.VKSByFingerprint))
+		r.With(httpin.NewInput(handler.VKSLookupParams{})).Get("/by-keyid/{id}", a.withDB(handler.VKSByKeyID))
+		r.With(httpin.NewInput(handler.VKSLookupParams{})).Get("/by-email/{id}", a.withDB(handler.VKSByEmail))
+		// Clients such as Thunderbird and OpenKeychain upload without credentials.
+		r.Group(func(r chi.Router) {
+			r.Use(RateLimit(config.Current.HTTP.UploadRateLimit, time.Minute))
+			r.Use(verificationOrTokenAuth)
+			r.With(httpin.NewInput(handler.VKSUploadParams{})).Post("/upload", a.withDB(handler.VKSUpload))
+			r.With(httpin.NewInput(handler.VKSVerifyParams{})).Post("/request-verify", func(w http.ResponseWriter, r *http.Request) {
+				handler.VKSRequestVerify(a.DB, a.Mailer, w, r)
+			})
+		})
+	})
+	a.Router.Route("/api/v1", func(r chi.Router) {
+		r.With(replicationAuth, httpin.NewInput(handler.ReplicationChangesParams{})).Get("/replication/changes", a.withDB(handler.ReplicationChanges))
+		r.Group(func(r chi.Router) {
+			r.Use(tokenAuth)
+			r.Route("/keys", func(r chi.Router) {
+				r.With(httpin.NewInput(handler.KeyListParams{})).Get("/", a.withDB(handler.ListKeys))
+				r.With(httpin.NewInput(handler.KeyUploadParams{})).Post("/", func(w http.ResponseWriter, r *http.Request) {
+					handler.UploadKeys(a.DB, a.Mailer, w, r)
+				})
+				r.With(httpin.NewInput(handler.KeyParams{})).Get("/{id}", a.withDB(handler.GetKey))
+				r.With(httpin.NewInput(handler.KeyParams{})).Delete("/{id}", a.withDB(handler.DeleteKey))
+			})
+			r.Get("/replication/status", func(w http.ResponseWriter, r *http.Request) {
+				handler.ReplicationStatus(a.Replication, w, r)
+			})
+		})
+	})
+	a.Router.Route("/admin", func(r chi.Router) {
+		r.Use(tokenAuth)
+		r.With(httpin.NewInput(handler.GPGKeyParams{})).Delete("/gpg/keys/{fingerprint}", a.withDB(handler.GPGPubKeyDelete))
+		r.Route("/webfinger/accounts", func(r chi.Router) {
+			r.With(httpin.NewInput(handler.WebFingerAccountListParams{})).Get("/", a.withDB(handler.ListWebFingerAccounts))
+			r.With(httpin.NewInput(handler.WebFingerAccountCreateParams{})).Post("/", a.withDB(handler.CreateWebFingerAccount))
+			r.Route("/{id}", func(r chi.Router) {
+				r.With(httpin.NewInput(handler.WebFingerAccountParams{})).Get("/", a.withDB(handler.GetWebFingerAccount))
+				r.With(httpin.NewInput(handler.WebFingerAccountUpdateParams{})).Put("/", a.withDB(handler.UpdateWebFingerAccount))
+				r.With(httpin.NewInput(handler.WebFingerAccountParams{})).Delete("/", a.withDB(handler.DeleteWebFingerAccount))
+				r.With(httpin.NewInput(handler.WebFingerAliasCreateParams{})).Post("/aliases", a.withDB(handler.AddWebFingerAlias))
+				r.With(httpin.NewInput(handler.WebFingerAliasParams{})).Delete("/aliases/{alias_id}", a.withDB(handler.DeleteWebFingerAlias))
+				r.With(httpin.NewInput(handler.WebFingerLinkCreateParams{})).Post("/links", a.withDB(handler.AddWebFingerLink))
+				r.With(httpin.NewInput(handler.WebFingerLinkUpdateParams{})).Put("/links/{link_id}", a.withDB(handler.UpdateWebFingerLink))
+				r.With(httpin.NewInput(handler.WebFingerLinkParams{})).Delete("/links/{link_id}", a.withDB(handler.DeleteWebFingerLink))
 			})
 		})
 	})
@@ -76,6 +201,10 @@ func (a *App) Serve() {
 		IdleTimeout:  time.Second * 60,
 	}
 
+	scheduler, stopScheduler := context.WithCancel(context.Background())
+	defer stopScheduler()
+	a.StartScheduler(scheduler)
+
 	slog.Info("Starting server", "address", addr)
 
 	// Run our server in a goroutine so that it doesn't block.
@@ -1,16 +1,30 @@
 package api
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"net/url"
 	"os"
+	"regexp"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
 	"github.com/hibare/DomainHQ/internal/api/handler"
 	"github.com/hibare/DomainHQ/internal/config"
+	"github.com/hibare/DomainHQ/internal/constants"
+	"github.com/hibare/DomainHQ/internal/mailer"
+	"github.com/hibare/DomainHQ/internal/models"
+	"github.com/hibare/DomainHQ/internal/replication"
+	"github.com/hibare/DomainHQ/internal/upstream"
 	commonMiddleware "github.com/hibare/GoCommon/v2/pkg/http/middleware"
 	"github.com/stretchr/testify/assert"
 	"gorm.io/gorm"
@@ -21,7 +35,286 @@ var (
 )
 
 const (
-	testAPIKey = "test-key"
+	testAPIKey           = "test-key"
+	testReplicationToken = "test-replication-token"
+
+	// secondTestKey shares its email with the key uploaded in TestGPGKeyAdd.
+	// testKeyring holds a new key, secondTestKey and a secret key, in that order.
+	testKeyring = `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+xjMEZZN9JRYJKwYBBAHaRw8BAQdAONX0l9cRwGENewz8Fj8t9Y46+79I7jYuEY6X
+I+cZbe7NGlRlYW0gT25lIDxvbmVAZXhhbXBsZS5jb20+wr0EExYIAG8FgmWTfSUC
+CwcJEJmEc0TX1ztYNRQAAAAAABwAEHNhbHRAbm90YXRpb25zLm9wZW5wZ3Bqcy5v
+cmdL6r7kfyBdfpjrqUnlNWoPAhUIAhYAAhkBApsDAh4BFiEEcOLsLH8pJq/ZNVnk
+mYRzRNfXO1gAAC6PAP9WaSfjM4fztjEYTklVsKUCEAEOqyj4lxFdFxAQgwHdSgD+
+JrXp9oi36DOLLS766c24Ar2YRvZLlQV8KGl5mpJnZwXOOARlk30lEgorBgEEAZdV
+AQUBAQdA3WsQHI8tyLdMtiGiT4uMFJnmPtFmC+z32LYXr/HNkBIDAQoJwq4EGBYI
+AGAFgmWTfSUJEJmEc0TX1ztYNRQAAAAAABwAEHNhbHRAbm90YXRpb25zLm9wZW5w
+Z3Bqcy5vcmd9XLUBYA07cDPlks1IlKXHApsMFiEEcOLsLH8pJq/ZNVnkmYRzRNfX
+O1gAABVaAP0awT9KvxMU2YVjzcErhP0LwBvJZpLquGvk2Bv9XHqOiAEAzpyKP6P8
+gNQK0xLREIB8RVuf3guY47dMT8I17v5oTQXGMwRlk30lFgkrBgEEAdpHDwEBB0Cw
+hH40kP22y+S8wb8HiiLCEjFs6QW4zfjt8g8n6J6e0M0pU2Vjb25kIChzZWNvbmQg
+a2V5KSA8ZXhhbXBsZUBleGFtcGxlLmNvbT7CvQQTFggAbwWCZZN9JQILBwkQZWB8
+W1qG5cw1FAAAAAAAHAAQc2FsdEBub3RhdGlvbnMub3BlbnBncGpzLm9yZ8w7BLQm
+Su4MMrjalR3CVXkCFQgCFgACGQECmwMCHgEWIQT/gfTLZwLTIYdZ3KNlYHxbWobl
+zAAATokA/j8rdyOy1jBRy2p6ZwQg2CRWT/WH1+OQdG1Yg6ZDLO9PAQCBlgU+CwR9
+O5/5OmKOiFKqcfF36YZz1KMsgUWqYuSlC844BGWTfSUSCisGAQQBl1UBBQEBB0CY
+n8uP9JC1zc4FxDCR7w3ittoPEJDbljOoaNcXjPlzZQMBCgnCrgQYFggAYAWCZZN9
+JQkQZWB8W1qG5cw1FAAAAAAAHAAQc2FsdEBub3RhdGlvbnMub3BlbnBncGpzLm9y
+Zy08T4jLARQrIi0V4Uj3w3QCmwwWIQT/gfTLZwLTIYdZ3KNlYHxbWoblzAAA3eAA
+/2Lpo8uHBNL80G3cQPYD/3d2JTRF7pnPaxdHqmpDqsp5APsG7nCdbH5yutbmUAoF
+E0WnzlXuYSq5X1ysqqUnSOiNDcVYBGWTfSUWCSsGAQQB2kcPAQEHQNeNwa5Rx6P8
+U05HtnkUc8jA8EO5nnYUTUEpsdsbu/cnAAD8D8JuFTqlaS23ycE9wyl8nCofqY4v
+3WV4ldSsvTQi/MsQn80aVGVhbSBUd28gPHR3b0BleGFtcGxlLmNvbT7CvQQTFggA
+bwWCZZN9JQILBwkQfgJMEUOkr701FAAAAAAAHAAQc2FsdEBub3RhdGlvbnMub3Bl
+bnBncGpzLm9yZ/UXyYW1nw8TBYGHQtCefgACFQgCFgACGQECmwMCHgEWIQSVWUSS
+AG7fqOP3pk9+AkwRQ6SvvQAA6esBAM5b3P5jWWZV1MefBXPv4EeHB/OSCRbcWVip
+9yaQd8TGAQCRFGA5Sfi/AponLfpJkpC7FksnRhpIEmAH3qOBWEUXBMddBGWTfSUS
+CisGAQQBl1UBBQEBB0BMbtvBEKYpB/xjqaIMx9DaCThUIb6jJoQI/uIvnk7yDAMB
+CgkAAP9Ww9jNQBPZsboQvJCPl7XU3Sawts3SQolK7jkyBmoL8BJAwq4EGBYIAGAF
+gmWTfSUJEH4CTBFDpK+9NRQAAAAAABwAEHNhbHRAbm90YXRpb25zLm9wZW5wZ3Bq
+cy5vcmc05baDufo+HBZYycUpQKVwApsMFiEElVlEkgBu36jj96ZPfgJMEUOkr70A
+AD93AP48JzpkUN1qeJ268xzJlX1oeej/uu7+s9d6NdWcUFoKEQEAr1pRwabKoRhC
+vGQvSuBF6uTDvJtnuPUXE/9UWR71dQA=
+=I+d9
+-----END PGP PUBLIC KEY BLOCK-----
+`
+
+	// secondTestKeyNewUID is secondTestKey with only a newer user ID and no subkeys.
+	secondTestKeyNewUID = `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+xjMEZZN9JRYJKwYBBAHaRw8BAQdAsIR+NJD9tsvkvMG/B4oiwhIxbOkFuM347fIP
+J+ientDNG1NlY29uZCA8c2Vjb25kQGV4YW1wbGUuY29tPsK6BBMWCABsBYJlvFul
+AgsHCRBlYHxbWoblzDUUAAAAAAAcABBzYWx0QG5vdGF0aW9ucy5vcGVucGdwanMu
+b3JnLlS2JkTOF+hZEd0tBEJR+QIVCAIWAAKbAwIeARYhBP+B9MtnAtMhh1nco2Vg
+fFtahuXMAAAv2gEAz3WT001Qtvz57SpNH63zLw7f1Y5zeFjTsXX81vFPAR4BAKEz
+utVr4U21kpMomhrCXH8e/Ws/gHBYRE/TrAPmA2EI
+=EGOy
+-----END PGP PUBLIC KEY BLOCK-----
+`
+
+	// thirdTestKey is only uploaded with email verification switched on.
+	thirdTestKey = `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+xjMEZZN9JRYJKwYBBAHaRw8BAQdAL9vQiml+bn6wyn2z5K4OIKDTfH4u5Lyf0Aau
+SEAtZSPNGVRoaXJkIDx0aGlyZEBleGFtcGxlLmNvbT7CvQQTFggAbwWCZZN9JQIL
+BwkQk4qn7tnqEYw1FAAAAAAAHAAQc2FsdEBub3RhdGlvbnMub3BlbnBncGpzLm9y
+Z1wG4V48RERsNMdUn5UyBWoCFQgCFgACGQECmwMCHgEWIQQOaIdYvk4E1kdHLK+T
+iqfu2eoRjAAAZbYA/0jGhvfE5pdSfNDMtruksVS/4WV8zGWdlK0GXZDmwvYpAQDQ
+USlp1/3gjm8rN2PHcqoObIxtqLYuUd9MRathFTo6Cc44BGWTfSUSCisGAQQBl1UB
+BQEBB0DM3tmpaNrGqLdOJUQdC2VbFovY9Y8qZCr4/EbYuGczUAMBCgnCrQQYFggA
+YAWCZZN9JQkQk4qn7tnqEYw1FAAAAAAAHAAQc2FsdEBub3RhdGlvbnMub3BlbnBn
+cGpzLm9yZyM90jkZPI9nY5q4ia4HfvACmwwWIQQOaIdYvk4E1kdHLK+Tiqfu2eoR
+jAAABvEBAO+zyp9vB8rhQIv1Kn0NkWAYuSsmf/kaNqa8FjKUKrM5APj1qhuhFhOf
+yNOdbALwHVIWSMUvrZy17ltRKqwLYRkE
+=T9r2
+-----END PGP PUBLIC KEY BLOCK-----
+`
+
+	// secondTestKeyRevocation is a bare revocation certificate for secondTestKey.
+	secondTestKeyRevocation = `-----BEGIN PGP PUBLIC KEY BLOCK-----
+Comment: This is a revocation certificate
+
+wrIEIBYIAGQFgmXilyUJEGVgfFtahuXMNRQAAAAAABwAEHNhbHRAbm90YXRpb25z
+Lm9wZW5wZ3Bqcy5vcmcn+YlZKTBZpHY5QB0tSWtUBp0CdGVzdBYhBP+B9MtnAtMh
+h1nco2VgfFtahuXMAADWiwEAyOnsCMJxq+oW4hrpXrw8SDIDi8q8Is7pKKd88rEV
+1aYBAKzyqMdtz08PwLp1u6m/6BQbJPtKwqfWltyNO9HVGxEC
+=4vGg
+-----END PGP PUBLIC KEY BLOCK-----
+`
+
+	// binaryTestKey is uploaded as raw packets in TestGPGKeyBinary.
+	binaryTestKey = `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+xjMEZZN9JRYJKwYBBAHaRw8BAQdAdq9akkyFrc3ecrxbahiEwgYk1eg0quBUcsy5
+SSTX3cTNG0JpbmFyeSA8YmluYXJ5QGV4YW1wbGUuY29tPsK9BBMWCABvBYJlk30l
+AgsHCRBgLboKDMCl5jUUAAAAAAAcABBzYWx0QG5vdGF0aW9ucy5vcGVucGdwanMu
+b3JnoaYBNd/sph/VWDI2diPn+gIVCAIWAAIZAQKbAwIeARYhBGRc7MueFmXUGXit
+0mAtugoMwKXmAABOAwEA+2lpQMqnjvLIZ0hQhQzkDho5Fu/ddMxueQi4ulvaxfAB
+AOQiEC8W17hGMSbmdqWfrNxijzU3ikGJ7D+4FISuwXIBzjgEZZN9JRIKKwYBBAGX
+VQEFAQEHQOSHRXVRk5lDrHzjebGuoz1LkyHFh3fmBGe8CAubLHtgAwEKCcKuBBgW
+CABgBYJlk30lCRBgLboKDMCl5jUUAAAAAAAcABBzYWx0QG5vdGF0aW9ucy5vcGVu
+cGdwanMub3JnWHR8b6B71EaGEyOWWSZRkAKbDBYhBGRc7MueFmXUGXit0mAtugoM
+wKXmAAAhoAEA7t1mNthZd7dxXfxgPtOEcROOrJ0RAwBpEb4Yj7AJ5jkBAMXPXL+y
+7IBIMgNnbbW7VcI0ieLGuBOaUJqfAyFiQYAM
+=n8Lm
+-----END PGP PUBLIC KEY BLOCK-----
+`
+
+	// apiTestKey is uploaded through the JSON API in TestKeysAPI.
+	apiTestKey = `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+xjMEZZN9JRYJKwYBBAHaRw8BAQdAC8dCDC2o1EsUqJVlKXtBiBqZskw+MWOsUuX5
+NlK6fxLNFUFwaSA8YXBpQGV4YW1wbGUuY29tPsK9BBMWCABvBYJlk30lAgsHCRAp
+YDwWW9XGizUUAAAAAAAcABBzYWx0QG5vdGF0aW9ucy5vcGVucGdwanMub3JnEq8+
+r+L7LvY5GwI66dUNgwIVCAIWAAIZAQKbAwIeARYhBMSJPxFoPQVCGb87VSlgPBZb
+1caLAADXLQD/RnEWWRj5dYJZAXfbN+NTTqZLrhoWOOCAMCN0bw2EUcoBAIgcVv8q
+jw2CPfW5e3awCRR0FVaHww/UPNPJpkRBv80KzjgEZZN9JRIKKwYBBAGXVQEFAQEH
+QHuEaYh9T1PyWue7SXtiwsdS3MwJ+eMwwoL7OeFlaAg9AwEKCcKuBBgWCABgBYJl
+k30lCRApYDwWW9XGizUUAAAAAAAcABBzYWx0QG5vdGF0aW9ucy5vcGVucGdwanMu
+b3JnOynSh2NH1fO7D0sZhc+dpwKbDBYhBMSJPxFoPQVCGb87VSlgPBZb1caLAABS
+JwD/Vn60yJlqqV7wAi6xedREcJwuqeLudR8TCcnnOy4vL4kA/j/j0PqXxRRIHqMY
+TTHQp4RX7FlApQo7XpOgrLfdoA4B
+=QiV7
+-----END PGP PUBLIC KEY BLOCK-----
+`
+
+	// vksTestKey is uploaded through the VKS API in TestVKS.
+	vksTestKey = `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+xjMEZZN9JRYJKwYBBAHaRw8BAQdA5EFx/JGd+wqPkjj1wnu9Ha3eQnMNzBRDDHSB
+lrH9v37NFVZrcyA8dmtzQGV4YW1wbGUuY29tPsK9BBMWCABvBYJlk30lAgsHCRAw
+kmoenzePtDUUAAAAAAAcABBzYWx0QG5vdGF0aW9ucy5vcGVucGdwanMub3JnoAAC
+YJEDglKYaMfKc2O0vwIVCAIWAAIZAQKbAwIeARYhBFIUlqwaIgj9jGMT+zCSah6f
+N4+0AABQzAD/WuVCRn7iE5vdxTFYfAWKWOLcXT87lXK8L2/fKb7y8A4BAIKslwjO
+SWELlPJaQ6k0ykOOD++6P65xj76J83y7kc8EzjgEZZN9JRIKKwYBBAGXVQEFAQEH
+QJBFo8zHrm2WojgGZXU7PhyaU6gAzpxMj8zSt5cGJ6JuAwEKCcKuBBgWCABgBYJl
+k30lCRAwkmoenzePtDUUAAAAAAAcABBzYWx0QG5vdGF0aW9ucy5vcGVucGdwanMu
+b3JnSfVkSWlW3cxySahdWZU35wKbDBYhBFIUlqwaIgj9jGMT+zCSah6fN4+0AADF
+GAEAt7AskVQ/79f2EIA1j10dFNg/dwiGgXQTWJnALUnR6osBANIxYz4vU+l9g72S
+A2Oh6uGxNaKW420sU5oFHXWqsP0O
+=E7L0
+-----END PGP PUBLIC KEY BLOCK-----
+`
+
+	// upstreamTestKey is only known to the stand-in keyserver of TestUpstreamLookup.
+	upstreamTestKey = `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+xjMEZZN9JRYJKwYBBAHaRw8BAQdA1TW5xtndiYqySmVPtaw8a+/6Mr4+TuBjknJn
+vJ01qVXNH1Vwc3RyZWFtIDx1cHN0cmVhbUBleGFtcGxlLm5ldD7CvQQTFggAbwWC
+ZZN9JQILBwkQheav5AGSZoo1FAAAAAAAHAAQc2FsdEBub3RhdGlvbnMub3BlbnBn
+cGpzLm9yZ5fTpmUTUg0wUPyStCp0QLECFQgCFgACGQECmwMCHgEWIQQAy6So+QDy
+dBHf8peF5q/kAZJmigAA2Y8A/1K8Rn9tbbFPj6SKsSydceVOqnpi1SPy9Cenx+/M
+/FgpAP9dRskpoLVhCn160ImVVu0/LgPOtJzL3lzVDyi6enciC844BGWTfSUSCisG
+AQQBl1UBBQEBB0DyQp2+AsLs1Np0Y4z/fkwr1BUT9xgHwcZiM66CaxopTQMBCgnC
+rgQYFggAYAWCZZN9JQkQheav5AGSZoo1FAAAAAAAHAAQc2FsdEBub3RhdGlvbnMu
+b3BlbnBncGpzLm9yZwgFO/fyAXhdalHhbWpxI+cCmwwWIQQAy6So+QDydBHf8peF
+5q/kAZJmigAAMaUBAJlskHmAhREE4MBhZx1dflQFksGyHPVIqSwR5+jfcFG8AP43
+xwmdXvFLCStbspL/SyKqGD/1esKrKuJLFvm+eS/mBg==
+=CEjR
+-----END PGP PUBLIC KEY BLOCK-----
+`
+
+	// replicatedTestKey only reaches the store through TestReplication's stand-in peer.
+	replicatedTestKey = `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+xjMEZZN9JRYJKwYBBAHaRw8BAQdABQea2cx6FzovRPnM2jkV85CGV8EdK7VYn6JO
+I7DMwVXNI1JlcGxpY2F0ZWQgPHJlcGxpY2F0ZWRAZXhhbXBsZS5jb20+wr0EExYI
+AG8FgmWTfSUCCwcJEF9t3wuCs8xYNRQAAAAAABwAEHNhbHRAbm90YXRpb25zLm9w
+ZW5wZ3Bqcy5vcmcSkBpjmavw/r1YKNXYgtiZAhUIAhYAAhkBApsDAh4BFiEEVdfs
+S3P777PMCHszX23fC4KzzFgAAIVcAP9LV8V+DxVe5vgpqAiQDo9T30bKEItCGYqg
+m0RReyl6vAD/akntNKqs6GgWU/XNo3lOKiajB7RxihrpXBgLidad4AzOOARlk30l
+EgorBgEEAZdVAQUBAQdA9VhtwEF4i6fvoex9xNgpDooNPS9c9krRR7tc1uhTtCID
+AQoJwq4EGBYIAGAFgmWTfSUJEF9t3wuCs8xYNRQAAAAAABwAEHNhbHRAbm90YXRp
+b25zLm9wZW5wZ3Bqcy5vcmcakmrGtjYfPlAuUHTjw0g9ApsMFiEEVdfsS3P777PM
+CHszX23fC4KzzFgAAKWRAQC2maJElEq8QiBYQ+cM28ZZ8um27k3Drtw8qIh6xVTs
+ZwEAmeB5mRjDpYxdW8bhdDAfIMvCfArQXsZQegAOk8eSRAw=
+=lWfn
+-----END PGP PUBLIC KEY BLOCK-----
+`
+
+	// policyTestKey has user IDs for example.com, gmail.com and one without an email.
+	policyTestKey = `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+xjMEZZN9JRYJKwYBBAHaRw8BAQdA3vrDOCRosjx/xAq8HMRfkGS+BsCbcC5CVEZu
+SqnPwAvNDVBvbGljeSBOb21haWzCugQTFggAbAWCZZN9JQILBwkQFcS+sOHr8B41
+FAAAAAAAHAAQc2FsdEBub3RhdGlvbnMub3BlbnBncGpzLm9yZ8vcDie+ZaxhxThG
+YCMGLSgCFQgCFgACmwMCHgEWIQTOyhwQJ9fB12jsvlkVxL6w4evwHgAABq8A/3g6
+/eKqoM3VGDFafk92/7pt4lvDGDQBtOY5MwNDa/GDAQD53akEC+UiZkWyw8Sru29E
+56PkwipFvamI+z5aUxqoAc0bUG9saWN5IDxwb2xpY3lAZXhhbXBsZS5jb20+wr0E
+ExYIAG8FgmWTfSUCCwcJEBXEvrDh6/AeNRQAAAAAABwAEHNhbHRAbm90YXRpb25z
+Lm9wZW5wZ3Bqcy5vcmfKq6Oj7XLK0SeiukElGrm/AhUIAhYAAhkBApsDAh4BFiEE
+zsocECfXwddo7L5ZFcS+sOHr8B4AALtpAP4i8xJdc93P5b/Y+Cx74c2jQLX7GAAV
+6meUaknJF4bY8AEAtZZdI9Ryd/8Q79OI0ZOJI6W0en+sAeITQq98mlDHWgXNGVBv
+bGljeSA8cG9saWN5QGdtYWlsLmNvbT7CugQTFggAbAWCZZN9JQILBwkQFcS+sOHr
+8B41FAAAAAAAHAAQc2FsdEBub3RhdGlvbnMub3BlbnBncGpzLm9yZ9QCt48d8Rn8
+rYMiTCbJijECFQgCFgACmwMCHgEWIQTOyhwQJ9fB12jsvlkVxL6w4evwHgAAFHQB
+AOYes+na5YOAOVwjTsLozpE582a8XV7tws6kcTfMSx24AQCx0HoXyI7ZLNNfkKtW
+v/TfBC/deQRn8fSthB38fBr6D844BGWTfSUSCisGAQQBl1UBBQEBB0AA4lxxk+B7
+pzmVWYzDwYSmOGBRCYuUf1PMO7E1rHd+GQMBCgnCrgQYFggAYAWCZZN9JQkQFcS+
+sOHr8B41FAAAAAAAHAAQc2FsdEBub3RhdGlvbnMub3BlbnBncGpzLm9yZ50/cRxF
+lBrSfZ1s+XPr12ACmwwWIQTOyhwQJ9fB12jsvlkVxL6w4evwHgAA4SMBANgt9A6b
+rr/lfLUfZwBLv8R64LIF/ULztu11v54K8t9AAP9coeV3pFq/FRFhkvBdY3oZSik3
+s0jryaS6l+ICfJDuCQ==
+=AndW
+-----END PGP PUBLIC KEY BLOCK-----
+`
+
+	// foreignTestKey only has a user ID outside of the configured domains.
+	foreignTestKey = `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+xjMEZZN9JRYJKwYBBAHaRw8BAQdAfVxUtz1+R/A8VmbUzOGlHxWCWmLxryr43Dc4
+VqBlv9nNG0ZvcmVpZ24gPGZvcmVpZ25AZ21haWwuY29tPsK9BBMWCABvBYJlk30l
+AgsHCRB4hyUmiItFcjUUAAAAAAAcABBzYWx0QG5vdGF0aW9ucy5vcGVucGdwanMu
+b3JnHvn6E8gnmHOE35eUK9HP3gIVCAIWAAIZAQKbAwIeARYhBMxj6lcwMf9QokZx
+C3iHJSaIi0VyAACvlwD9HmGYCyQd6bQuqZdYpWZrDxKmt7pAB7VwmdpgBes01QoB
+AMthjlOJv6vEGdebuSviGPvt1j7kdj6arxxrEbZnSCoKzjgEZZN9JRIKKwYBBAGX
+VQEFAQEHQM5TEU8G64b5HPSF/EXcaUiaG5SVHN8sqQsZV/83UpEWAwEKCcKuBBgW
+CABgBYJlk30lCRB4hyUmiItFcjUUAAAAAAAcABBzYWx0QG5vdGF0aW9ucy5vcGVu
+cGdwanMub3JniYuKeB+NhWMhWNYQueDOtAKbDBYhBMxj6lcwMf9QokZxC3iHJSaI
+i0VyAAAuzQD+NTltQaNvaHd1HxxHus2woujisOwLkQsXpTsZi+Icum8BAI5rF/iu
+ioHi+2IHIqnUciEAhNEKsimY0amvvzBzz5sA
+=i6gC
+-----END PGP PUBLIC KEY BLOCK-----
+`
+
+	// legacyTestKey has one user ID inside and one outside of the configured domains.
+	legacyTestKey = `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+xjMEZZN9JRYJKwYBBAHaRw8BAQdAfi2W63qKTpBHoULlV+fNcahtjVRDjnJKdQ6H
+PnD3jdLNG0xlZ2FjeSA8bGVnYWN5QGV4YW1wbGUuY29tPsK9BBMWCABvBYJlk30l
+AgsHCRB2d6Iq370djzUUAAAAAAAcABBzYWx0QG5vdGF0aW9ucy5vcGVucGdwanMu
+b3JndpKR696QwNWSEMJ2LhBAngIVCAIWAAIZAQKbAwIeARYhBLbuL2Vlk9PPFU25
+KnZ3oirfvR2PAACurQEA3HqAftuiRJiaAR6zNcgUpB+Xa0JPHvPRjL8kCy8LN3wA
+/i+Q9fh87UBk0D4g0MCWm3STcb4/0atAexMTAELQwd0CzRlMZWdhY3kgPGxlZ2Fj
+eUBnbWFpbC5jb20+wroEExYIAGwFgmWTfSUCCwcJEHZ3oirfvR2PNRQAAAAAABwA
+EHNhbHRAbm90YXRpb25zLm9wZW5wZ3Bqcy5vcmdDKPaXRmzpIfbCG9nMiG88AhUI
+AhYAApsDAh4BFiEEtu4vZWWT088VTbkqdneiKt+9HY8AACeRAP4uBePb6AfmNB4U
+IYO4mnkgWy1GC3vo9v+bA/w1p658BQEAzNa2QC9Tfyj6lgq4zfnDfbwVYIORGmOb
+Xy5woeVBHwrOOARlk30lEgorBgEEAZdVAQUBAQdASSai0ER6vQBTmeLjbj13NWWk
+OLMLI67j78WGy6Qo/SgDAQoJwq4EGBYIAGAFgmWTfSUJEHZ3oirfvR2PNRQAAAAA
+ABwAEHNhbHRAbm90YXRpb25zLm9wZW5wZ3Bqcy5vcmfwDMBzK2PXlg0SbC3Ln4k+
+ApsMFiEEtu4vZWWT088VTbkqdneiKt+9HY8AADR6AQDBI0KlI76lu5rBbqVsyjJg
+h9WMvNMg21DEaB93J1WJywD9HugTlpnfTCiXO/o1y1NocgamF9LKMvWT/7o8RkhV
+rww=
+=oPrf
+-----END PGP PUBLIC KEY BLOCK-----
+`
+
+	// expiredTestKey was created on 2024-01-02 and expired a day later.
+	expiredTestKey = `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+xjMEZZN9JRYJKwYBBAHaRw8BAQdAbEtYtGIJkc6vFuFYeoB/YGCVdNvWxmWBJvrG
+iP+GrynNHUV4cGlyZWQgPGV4cGlyZWRAZXhhbXBsZS5jb20+wsADBBMWCAB1BYJl
+k30lBYkAAVGAAgsHCRBAe9cKv/Q8bDUUAAAAAAAcABBzYWx0QG5vdGF0aW9ucy5v
+cGVucGdwanMub3Jn5fwOVVWDoKg347nfKJqFWQIVCAIWAAIZAQKbAwIeARYhBGN7
++v8+KVLn5V4Ac0B71wq/9DxsAABSFQEA6PgYOZpal1xeNaLQVfc5RIfzJm+MghEL
+svhZLAp06KwBANqovbHFondOtnkrVl6Rsl5GQssAmHHX5N2ywVdLY4ENzjgEZZN9
+JRIKKwYBBAGXVQEFAQEHQIWkSjWmP6jPswEuxQZRHRYCIONDKBGLWMD2BPNO1W8b
+AwEKCcKuBBgWCABgBYJlk30lCRBAe9cKv/Q8bDUUAAAAAAAcABBzYWx0QG5vdGF0
+aW9ucy5vcGVucGdwanMub3Jni7+ZgmH8umTbmozZ6UGCMwKbDBYhBGN7+v8+KVLn
+5V4Ac0B71wq/9DxsAADsYwD/ayM3m5OPOjepQnIEvSrbDIjZiapyHByTpZy7bqof
+5D4A/jJT2VX82pXP0BKLeB2Utwb4NLScF9+25hVlhUJAeDAH
+=5+6p
+-----END PGP PUBLIC KEY BLOCK-----
+`
+
+	secondTestKey = `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+xjMEZZN9JRYJKwYBBAHaRw8BAQdAsIR+NJD9tsvkvMG/B4oiwhIxbOkFuM347fIP
+J+ientDNKVNlY29uZCAoc2Vjb25kIGtleSkgPGV4YW1wbGVAZXhhbXBsZS5jb20+
+wr0EExYIAG8FgmWTfSUCCwcJEGVgfFtahuXMNRQAAAAAABwAEHNhbHRAbm90YXRp
+b25zLm9wZW5wZ3Bqcy5vcmfMOwS0JkruDDK42pUdwlV5AhUIAhYAAhkBApsDAh4B
+FiEE/4H0y2cC0yGHWdyjZWB8W1qG5cwAAE6JAP4/K3cjstYwUctqemcEINgkVk/1
+h9fjkHRtWIOmQyzvTwEAgZYFPgsEfTuf+TpijohSqnHxd+mGc9SjLIFFqmLkpQvO
+OARlk30lEgorBgEEAZdVAQUBAQdAmJ/Lj/SQtc3OBcQwke8N4rbaDxCQ25YzqGjX
+F4z5c2UDAQoJwq4EGBYIAGAFgmWTfSUJEGVgfFtahuXMNRQAAAAAABwAEHNhbHRA
+bm90YXRpb25zLm9wZW5wZ3Bqcy5vcmctPE+IywEUKyItFeFI98N0ApsMFiEE/4H0
+y2cC0yGHWdyjZWB8W1qG5cwAAN3gAP9i6aPLhwTS/NBt3ED2A/93diU0Re6Zz2sX
+R6pqQ6rKeQD7Bu5wnWx+crrW5lAKBRNFp85V7mEquV9crKqlJ0jojQ0=
+=SWJv
+-----END PGP PUBLIC KEY BLOCK-----
+`
 )
 
 func setEnv() {
@@ -29,6 +322,9 @@ func setEnv() {
 	os.Setenv("DOMAIN_HQ_DB_PASSWORD", "pwd0123456789")
 	os.Setenv("DOMAIN_HQ_DB_NAME", "domain_hq_test")
 	os.Setenv("DOMAIN_HQ_API_KEYS", testAPIKey)
+	os.Setenv("DOMAIN_HQ_REPLICATION_TOKENS", testReplicationToken)
+	os.Setenv("DOMAIN_HQ_MAILER", constants.MailerBackendMemory)
+	os.Setenv("DOMAIN_HQ_UPLOAD_RATE_LIMIT", "100")
 }
 
 func unsetEnv() {
@@ -38,6 +334,9 @@ func unsetEnv() {
 	os.Unsetenv("DOMAIN_HQ_DB_PORT")
 	os.Unsetenv("DOMAIN_HQ_DB_NAME")
 	os.Unsetenv("DOMAIN_HQ_API_KEYS")
+	os.Unsetenv("DOMAIN_HQ_REPLICATION_TOKENS")
+	os.Unsetenv("DOMAIN_HQ_MAILER")
+	os.Unsetenv("DOMAIN_HQ_UPLOAD_RATE_LIMIT")
 }
 
 func TruncateTables(db *gorm.DB) {
@@ -75,6 +374,15 @@ func TestHome(t *testing.T) {
 }
 
 func TestWebFinger(t *testing.T) {
+	err := models.CreateWebFingerAccount(app.DB, &models.WebFingerAccount{
+		Subject: "acct:test@example.com",
+		Aliases: []models.WebFingerAlias{
+			{URI: "https://example.com/~test"},
+			{URI: "device:test-laptop"},
+		},
+	})
+	assert.NoError(t, err)
+
 	testCases := []struct {
 		Name         string
 		URL          string
@@ -95,6 +403,11 @@ func TestWebFinger(t *testing.T) {
 			URL:          "/.well-known/webfinger?resource=acct:test@example.com",
 			ExpectStatus: http.StatusOK,
 		},
+		{
+			Name:         "Account not found",
+			URL:          "/.well-known/webfinger?resource=acct:unknown@example.com",
+			ExpectStatus: http.StatusNotFound,
+		},
 		{
 			Name:         "Domain not allowed",
 			URL:          "/.well-known/webfinger?resource=acct:test@example1.com",
@@ -105,6 +418,31 @@ func TestWebFinger(t *testing.T) {
 			URL:          "/.well-known/webfinger?resource=test@example1.com",
 			ExpectStatus: http.StatusBadRequest,
 		},
+		{
+			Name:         "Mailto resource",
+			URL:          "/.well-known/webfinger?resource=mailto:test@example.com",
+			ExpectStatus: http.StatusOK,
+		},
+		{
+			Name:         "Profile URL resource",
+			URL:          "/.well-known/webfinger?resource=https://example.com/~test",
+			ExpectStatus: http.StatusOK,
+		},
+		{
+			Name:         "Device resource",
+			URL:          "/.well-known/webfinger?resource=device:test-laptop",
+			ExpectStatus: http.StatusOK,
+		},
+		{
+			Name:         "Unknown alias",
+			URL:          "/.well-known/webfinger?resource=device:unknown",
+			ExpectStatus: http.StatusNotFound,
+		},
+		{
+			Name:         "Unsupported scheme",
+			URL:          "/.well-known/webfinger?resource=ftp://example.com/test",
+			ExpectStatus: http.StatusBadRequest,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -118,10 +456,11 @@ func TestWebFinger(t *testing.T) {
 			if tc.ExpectStatus == http.StatusOK {
 				expectedBody := handler.WebFingerResponse{
 					Subject: "acct:test@example.com",
+					Aliases: []string{"https://example.com/~test", "device:test-laptop"},
 					Links: []handler.Link{
 						{
 							Rel:  handler.REL,
-							Href: config.Current.WebFinger.Resource,
+							Href: config.Current.WebFinger.Domains[0].Resource,
 						},
 					},
 				}
@@ -134,6 +473,75 @@ func TestWebFinger(t *testing.T) {
 	}
 }
 
+func TestWebFingerRelFilter(t *testing.T) {
+	profileRel := "http://webfinger.net/rel/profile-page"
+	err := models.CreateWebFingerAccount(app.DB, &models.WebFingerAccount{
+		Subject: "acct:rel@example.com",
+		Aliases: []models.WebFingerAlias{{URI: "https://example.com/~rel"}},
+		Links: []models.WebFingerLink{
+			{
+				Rel:    profileRel,
+				Type:   "text/html",
+				Href:   "https://example.com/~rel",
+				Titles: models.JSONMap[string]{"en-us": "Profile"},
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		Name        string
+		Query       string
+		ExpectLinks []handler.Link
+	}{
+		{
+			Name:  "No rel",
+			Query: "resource=acct:rel@example.com",
+			ExpectLinks: []handler.Link{
+				{Rel: handler.REL, Href: config.Current.WebFinger.Domains[0].Resource},
+				{Rel: profileRel, Type: "text/html", Href: "https://example.com/~rel", Titles: map[string]string{"en-us": "Profile"}},
+			},
+		},
+		{
+			Name:  "Single rel",
+			Query: "resource=acct:rel@example.com&rel=" + profileRel,
+			ExpectLinks: []handler.Link{
+				{Rel: profileRel, Type: "text/html", Href: "https://example.com/~rel", Titles: map[string]string{"en-us": "Profile"}},
+			},
+		},
+		{
+			Name:  "Multiple rel",
+			Query: "resource=acct:rel@example.com&rel=" + profileRel + "&rel=" + handler.REL,
+			ExpectLinks: []handler.Link{
+				{Rel: handler.REL, Href: config.Current.WebFinger.Domains[0].Resource},
+				{Rel: profileRel, Type: "text/html", Href: "https://example.com/~rel", Titles: map[string]string{"en-us": "Profile"}},
+			},
+		},
+		{
+			Name:        "Unknown rel",
+			Query:       "resource=acct:rel@example.com&rel=http://example.com/rel/unknown",
+			ExpectLinks: []handler.Link{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r, err := http.NewRequest("GET", "/.well-known/webfinger?"+tc.Query, nil)
+			assert.NoError(t, err)
+			app.Router.ServeHTTP(w, r)
+			assert.Equal(t, http.StatusOK, w.Code)
+
+			responseBody := handler.WebFingerResponse{}
+			err = json.NewDecoder(w.Body).Decode(&responseBody)
+			assert.NoError(t, err)
+			assert.Equal(t, "acct:rel@example.com", responseBody.Subject)
+			assert.Equal(t, []string{"https://example.com/~rel"}, responseBody.Aliases)
+			assert.Equal(t, tc.ExpectLinks, responseBody.Links)
+		})
+	}
+}
+
 func TestGPGKeyAdd(t *testing.T) {
 	testCases := []struct {
 		Name         string
@@ -210,11 +618,47 @@ func TestGPGPubKeyLookup(t *testing.T) {
 			ExpectStatus: http.StatusOK,
 			Query:        "op=get&search=B44DA0D3",
 		},
+		{
+			Name:         "Lookup by subkey ID - 200",
+			URL:          "/pks/lookup",
+			ExpectStatus: http.StatusOK,
+			Query:        "op=get&search=0xBEEC894148003B9A",
+		},
+		{
+			Name:         "Lookup by subkey fingerprint - 200",
+			URL:          "/pks/lookup",
+			ExpectStatus: http.StatusOK,
+			Query:        "op=get&search=0xE475C00AEDAACB258E273671BEEC894148003B9A",
+		},
+		{
+			Name:         "Index by subkey ID - 200",
+			URL:          "/pks/lookup",
+			ExpectStatus: http.StatusOK,
+			Query:        "op=index&search=0x48003B9A",
+		},
 		{
 			Name:         "Invalid op",
 			URL:          "/pks/lookup",
 			ExpectStatus: http.StatusBadRequest,
-			Query:        "op=index&search=B44DA0D3",
+			Query:        "op=stats&search=B44DA0D3",
+		},
+		{
+			Name:         "Index by email - 200",
+			URL:          "/pks/lookup",
+			ExpectStatus: http.StatusOK,
+			Query:        "op=index&search=example@example.com",
+		},
+		{
+			Name:         "Verbose index by Key ID - 200",
+			URL:          "/pks/lookup",
+			ExpectStatus: http.StatusOK,
+			Query:        "op=vindex&search=0xFE066B04B44DA0D3",
+		},
+		{
+			Name:         "Index not found",
+			URL:          "/pks/lookup",
+			ExpectStatus: http.StatusNotFound,
+			Query:        "op=index&search=example@example.in",
 		},
 		{
 			Name:         "No query",
@@ -239,3 +683,1592 @@ func TestGPGPubKeyLookup(t *testing.T) {
 		})
 	}
 }
+
+func TestGPGPubKeyIndex(t *testing.T) {
+	t.Run("Machine readable", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r, err := http.NewRequest("GET", "/pks/lookup?op=index&options=mr&search=example", nil)
+		assert.NoError(t, err)
+		app.Router.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, handler.ContentTypeText, w.Header().Get("Content-Type"))
+		assert.Equal(t, "info:1:1\n"+
+			"pub:22A37A9A70E3965157E16007FE066B04B44DA0D3:1:4096:1689359110::\n"+
+			"uid:Example (example key) <example@example.com>:1689359110::\n", w.Body.String())
+	})
+
+	t.Run("HTML", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r, err := http.NewRequest("GET", "/pks/lookup?op=vindex&search=example", nil)
+		assert.NoError(t, err)
+		app.Router.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, handler.ContentTypeHTML, w.Header().Get("Content-Type"))
+		assert.Contains(t, w.Body.String(), "FE066B04B44DA0D3</a> 2023-07-14")
+		assert.Contains(t, w.Body.String(), "uid  Example (example key) &lt;example@example.com&gt;")
+		assert.Contains(t, w.Body.String(), "sig     FE066B04B44DA0D3 2023-07-14")
+		assert.Contains(t, w.Body.String(), "sub  rsa4096/BEEC894148003B9A 2023-07-14")
+	})
+}
+
+func TestGPGPubKeyLookupMultiple(t *testing.T) {
+	w := httptest.NewRecorder()
+	r, err := http.NewRequest("POST", "/pks/add", strings.NewReader("keytext="+url.QueryEscape(secondTestKey)))
+	assert.NoError(t, err)
+	r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Add(commonMiddleware.AuthHeaderName, testAPIKey)
+	app.Router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	t.Run("Get all matches", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r, err := http.NewRequest("GET", "/pks/lookup?op=get&search=example@example.com", nil)
+		assert.NoError(t, err)
+		app.Router.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		entities, err := openpgp.ReadArmoredKeyRing(w.Body)
+		assert.NoError(t, err)
+		assert.Len(t, entities, 2)
+	})
+
+	t.Run("Exact index", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r, err := http.NewRequest("GET", "/pks/lookup?op=index&options=mr&exact=on&search=example", nil)
+		assert.NoError(t, err)
+		app.Router.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+
+		w = httptest.NewRecorder()
+		r, err = http.NewRequest("GET", "/pks/lookup?op=index&options=mr&exact=on&search=example@example.com", nil)
+		assert.NoError(t, err)
+		app.Router.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.True(t, strings.HasPrefix(w.Body.String(), "info:1:2\n"))
+	})
+
+	t.Run("Index with fingerprints", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r, err := http.NewRequest("GET", "/pks/lookup?op=index&fingerprint=on&search=0xFF81F4CB6702D3218759DCA365607C5B5A86E5CC", nil)
+		assert.NoError(t, err)
+		app.Router.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "Key fingerprint = FF81 F4CB 6702 D321 8759 DCA3 6560 7C5B 5A86 E5CC")
+	})
+}
+
+func TestGPGKeyAddKeyring(t *testing.T) {
+	w := httptest.NewRecorder()
+	r, err := http.NewRequest("POST", "/pks/add", strings.NewReader("keytext="+url.QueryEscape(testKeyring)))
+	assert.NoError(t, err)
+	r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Add(commonMiddleware.AuthHeaderName, testAPIKey)
+	app.Router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	results := []models.KeyImportResult{}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&results))
+	assert.Len(t, results, 3)
+	assert.Equal(t, models.KeyImportResult{Fingerprint: "70e2ec2c7f2926afd93559e499847344d7d73b58", Status: models.KeyAdded}, results[0])
+	assert.Equal(t, models.KeyImportResult{Fingerprint: "ff81f4cb6702d3218759dca365607c5b5a86e5cc", Status: models.KeyUnchanged}, results[1])
+	assert.Equal(t, models.KeyRejected, results[2].Status)
+	assert.NotEmpty(t, results[2].Reason)
+
+	w = httptest.NewRecorder()
+	r, err = http.NewRequest("GET", "/pks/lookup?op=get&search=one@example.com", nil)
+	assert.NoError(t, err)
+	app.Router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	r, err = http.NewRequest("GET", "/pks/lookup?op=get&search=two@example.com", nil)
+	assert.NoError(t, err)
+	app.Router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestGPGKeyAddMerge(t *testing.T) {
+	addKey := func(keyText string) models.KeyImportResult {
+		w := doFormRequest(t, "POST", "/pks/add", testAPIKey, "keytext="+url.QueryEscape(keyText))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		results := []models.KeyImportResult{}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&results))
+		assert.Len(t, results, 1)
+		return results[0]
+	}
+
+	assert.Equal(t, models.KeyUpdated, addKey(secondTestKeyNewUID).Status)
+	assert.Equal(t, models.KeyUnchanged, addKey(secondTestKey).Status)
+	assert.Equal(t, models.KeyUnchanged, addKey(secondTestKeyNewUID).Status)
+
+	w := httptest.NewRecorder()
+	r, err := http.NewRequest("GET", "/pks/lookup?op=get&search=0xFF81F4CB6702D3218759DCA365607C5B5A86E5CC", nil)
+	assert.NoError(t, err)
+	app.Router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	entities, err := openpgp.ReadArmoredKeyRing(w.Body)
+	assert.NoError(t, err)
+	assert.Len(t, entities, 1)
+	assert.Len(t, entities[0].Identities, 2)
+	assert.Len(t, entities[0].Subkeys, 1)
+
+	var subkeys int64
+	assert.NoError(t, app.DB.Model(&models.GPGSubkey{}).Where("parent_key_id = ?", "65607c5b5a86e5cc").Count(&subkeys).Error)
+	assert.Equal(t, int64(1), subkeys)
+
+	assertUsers(t, "65607c5b5a86e5cc", "example@example.com", "second@example.com")
+}
+
+// doRequest serves a request through the app router, authenticated with apiKey when set.
+func doRequest(t *testing.T, method, url, apiKey, contentType string, body io.Reader) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r, err := http.NewRequest(method, url, body)
+	assert.NoError(t, err)
+	if contentType != "" {
+		r.Header.Add("Content-Type", contentType)
+	}
+	if apiKey != "" {
+		r.Header.Add(commonMiddleware.AuthHeaderName, apiKey)
+	}
+	app.Router.ServeHTTP(w, r)
+	return w
+}
+
+// doFormRequest sends a form encoded body.
+func doFormRequest(t *testing.T, method, url, apiKey, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	return doRequest(t, method, url, apiKey, "application/x-www-form-urlencoded", strings.NewReader(body))
+}
+
+// doJSONRequest sends body encoded as JSON. A string is sent as is, so tests can send
+// malformed documents, and nil sends no body at all.
+func doJSONRequest(t *testing.T, method, url, apiKey string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader io.Reader
+	switch body := body.(type) {
+	case nil:
+	case string:
+		reader = strings.NewReader(body)
+	default:
+		payload, err := json.Marshal(body)
+		assert.NoError(t, err)
+		reader = bytes.NewReader(payload)
+	}
+	return doRequest(t, method, url, apiKey, "application/json", reader)
+}
+
+// assertUsers checks the stored user IDs of a key, exactly one of which must be primary.
+func assertUsers(t *testing.T, keyID string, emails ...string) {
+	users := []models.GPGUsers{}
+	assert.NoError(t, app.DB.Where("key_id = ?", keyID).Find(&users).Error)
+
+	stored := []string{}
+	primary := 0
+	for _, user := range users {
+		stored = append(stored, user.Email)
+		assert.False(t, user.SignedAt.IsZero())
+		if user.Primary {
+			primary++
+		}
+	}
+	assert.ElementsMatch(t, emails, stored)
+	assert.Equal(t, 1, primary)
+}
+
+func TestGPGUsersMigration(t *testing.T) {
+	// Recreate the user ID table the way it was before it had a key_id column.
+	assert.NoError(t, app.DB.Migrator().DropTable(&models.GPGUsers{}))
+	assert.NoError(t, app.DB.Exec("CREATE TABLE gpg_users (id text PRIMARY KEY, name text, email text, comment text)").Error)
+	assert.NoError(t, app.DB.Exec("INSERT INTO gpg_users (id, name, email, comment) VALUES (?, ?, ?, ?)", "65607c5b5a86e5cc", "Second", "example@example.com", "second key").Error)
+	assert.NoError(t, app.DB.Where("id = ?", "0002_gpg_users_key_id").Delete(&models.SchemaMigration{}).Error)
+
+	assert.NoError(t, models.Migrate(app.DB))
+
+	assertUsers(t, "65607c5b5a86e5cc", "example@example.com", "second@example.com")
+	assertUsers(t, "fe066b04b44da0d3", "example@example.com")
+
+	// Applied migrations are not run again.
+	assert.NoError(t, models.Migrate(app.DB))
+	assertUsers(t, "65607c5b5a86e5cc", "example@example.com", "second@example.com")
+}
+
+func TestGPGUsersWKDHashMigration(t *testing.T) {
+	// Forget the hashes the way rows stored before the column existed look.
+	assert.NoError(t, app.DB.Model(&models.GPGUsers{}).Where("1 = 1").UpdateColumn("wkd_hash", "").Error)
+	assert.NoError(t, app.DB.Where("id = ?", "0004_gpg_users_wkd_hash").Delete(&models.SchemaMigration{}).Error)
+
+	assert.NoError(t, models.Migrate(app.DB))
+
+	users := []models.GPGUsers{}
+	assert.NoError(t, app.DB.Where("key_id = ?", "fe066b04b44da0d3").Find(&users).Error)
+	assert.NotEmpty(t, users)
+	for _, user := range users {
+		assert.Equal(t, models.WKDHash(strings.Split(user.Email, "@")[0]), user.WKDHash)
+	}
+}
+
+func TestWKD(t *testing.T) {
+	testCases := []struct {
+		Name         string
+		URL          string
+		ExpectStatus int
+		ExpectUIDs   []string
+	}{
+		{
+			Name:         "Direct policy",
+			URL:          "/.well-known/openpgpkey/policy",
+			ExpectStatus: http.StatusOK,
+		},
+		{
+			Name:         "Advanced policy",
+			URL:          "/.well-known/openpgpkey/example.com/policy",
+			ExpectStatus: http.StatusOK,
+		},
+		{
+			Name:         "Unknown domain policy",
+			URL:          "/.well-known/openpgpkey/example.in/policy",
+			ExpectStatus: http.StatusNotFound,
+		},
+		{
+			Name:         "Direct method",
+			URL:          "/.well-known/openpgpkey/hu/apr3aj3jqcf89yd69qd8pkjp3pzawxhx?l=example",
+			ExpectStatus: http.StatusOK,
+			ExpectUIDs:   []string{"Example (example key) <example@example.com>", "Second (second key) <example@example.com>"},
+		},
+		{
+			Name:         "Advanced method",
+			URL:          "/.well-known/openpgpkey/example.com/hu/gwzzokpn8bfoy8gbfcgncr68k3nww85k",
+			ExpectStatus: http.StatusOK,
+			ExpectUIDs:   []string{"Second <second@example.com>"},
+		},
+		{
+			Name:         "Mismatched local part",
+			URL:          "/.well-known/openpgpkey/hu/apr3aj3jqcf89yd69qd8pkjp3pzawxhx?l=second",
+			ExpectStatus: http.StatusNotFound,
+		},
+		{
+			Name:         "Unknown hash",
+			URL:          "/.well-known/openpgpkey/example.com/hu/ybndrfg8ejkmcpqxot1uwisza345h769",
+			ExpectStatus: http.StatusNotFound,
+		},
+		{
+			Name:         "Unknown domain",
+			URL:          "/.well-known/openpgpkey/example.in/hu/apr3aj3jqcf89yd69qd8pkjp3pzawxhx",
+			ExpectStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r, err := http.NewRequest("GET", tc.URL, nil)
+			assert.NoError(t, err)
+			r.Host = "example.com"
+			app.Router.ServeHTTP(w, r)
+			assert.Equal(t, tc.ExpectStatus, w.Code)
+
+			if tc.ExpectUIDs == nil {
+				return
+			}
+			assert.Equal(t, handler.ContentTypeOctetStream, w.Header().Get("Content-Type"))
+
+			entities, err := openpgp.ReadKeyRing(w.Body)
+			assert.NoError(t, err)
+			uids := []string{}
+			for _, entity := range entities {
+				for name := range entity.Identities {
+					uids = append(uids, name)
+				}
+			}
+			assert.ElementsMatch(t, tc.ExpectUIDs, uids)
+		})
+	}
+}
+
+func TestGPGKeyVerification(t *testing.T) {
+	verificationConfig, publicURL := config.Current.Verification, config.Current.Server.PublicURL
+	defer func() {
+		config.Current.Verification, config.Current.Server.PublicURL = verificationConfig, publicURL
+	}()
+	config.Current.Verification = config.VerificationConfig{Enabled: true, Secret: "test-secret", TokenTTL: time.Hour}
+	config.Current.Server.PublicURL = "https://keys.example.com"
+
+	outbox := app.Mailer.(*mailer.MemoryMailer)
+	outbox.Reset()
+
+	w := doFormRequest(t, "POST", "/pks/add", testAPIKey, "keytext="+url.QueryEscape(thirdTestKey))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	results := []models.KeyImportResult{}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&results))
+	assert.Equal(t, []string{"third@example.com"}, results[0].Unverified)
+
+	messages := outbox.Messages()
+	assert.Len(t, messages, 1)
+	assert.Equal(t, "third@example.com", messages[0].To)
+
+	assert.Equal(t, http.StatusNotFound, doRequest(t, "GET", "/pks/lookup?op=get&search=third@example.com", "", "", nil).Code)
+	w = doRequest(t, "GET", "/pks/lookup?op=index&options=mr&search=0x0E688758BE4E04D647472CAF938AA7EED9EA118C", "", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "uid:")
+
+	link := regexp.MustCompile(`https://keys\.example\.com(/pks/verify\?token=(\S+))`).FindStringSubmatch(messages[0].Body)
+	assert.Len(t, link, 3)
+	token, err := url.QueryUnescape(link[2])
+	assert.NoError(t, err)
+
+	assert.Equal(t, http.StatusBadRequest, doRequest(t, "GET", "/pks/verify?token=invalid", "", "", nil).Code)
+	assert.Equal(t, http.StatusBadRequest, doFormRequest(t, "POST", "/pks/verify", "", "token=invalid").Code)
+
+	// Opening the link only asks for confirmation, as mail scanners open links too.
+	w = doRequest(t, "GET", link[1], "", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, handler.ContentTypeHTML, w.Header().Get("Content-Type"))
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	assert.Contains(t, w.Body.String(), `<form method="post" action="https://keys.example.com/pks/verify">`)
+	assert.Contains(t, w.Body.String(), `value="`+token+`"`)
+	assert.Equal(t, http.StatusNotFound, doRequest(t, "GET", "/pks/lookup?op=get&search=third@example.com", "", "", nil).Code)
+
+	assert.Equal(t, http.StatusOK, doFormRequest(t, "POST", "/pks/verify", "", "token="+url.QueryEscape(token)).Code)
+	assert.Equal(t, http.StatusOK, doFormRequest(t, "POST", "/pks/verify", "", "token="+url.QueryEscape(token)).Code)
+
+	w = doRequest(t, "GET", "/pks/lookup?op=get&search=third@example.com", "", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	entities, err := openpgp.ReadArmoredKeyRing(w.Body)
+	assert.NoError(t, err)
+	assert.Len(t, entities, 1)
+	assert.Contains(t, entities[0].Identities, "Third <third@example.com>")
+
+	outbox.Reset()
+	w = doFormRequest(t, "POST", "/pks/add", testAPIKey, "keytext="+url.QueryEscape(thirdTestKey))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, outbox.Messages())
+}
+
+func TestGPGKeyRevocationAndDelete(t *testing.T) {
+	t.Run("Revocation certificate", func(t *testing.T) {
+		w := doFormRequest(t, "POST", "/pks/add", testAPIKey, "keytext="+url.QueryEscape(secondTestKeyRevocation))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		results := []models.KeyImportResult{}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&results))
+		assert.Equal(t, []models.KeyImportResult{{Fingerprint: "ff81f4cb6702d3218759dca365607c5b5a86e5cc", Status: models.KeyUpdated}}, results)
+
+		w = doRequest(t, "GET", "/pks/lookup?op=index&options=mr&search=0xFF81F4CB6702D3218759DCA365607C5B5A86E5CC", "", "", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		lines := strings.Split(w.Body.String(), "\n")
+		assert.True(t, strings.HasPrefix(lines[1], "pub:FF81F4CB6702D3218759DCA365607C5B5A86E5CC:"))
+		assert.True(t, strings.HasSuffix(lines[1], ":r"))
+
+		w = doFormRequest(t, "POST", "/pks/add", testAPIKey, "keytext="+url.QueryEscape(secondTestKeyRevocation))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&results))
+		assert.Equal(t, models.KeyUnchanged, results[0].Status)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		keyURL := "/admin/gpg/keys/0x70E2EC2C7F2926AFD93559E499847344D7D73B58"
+
+		assert.Equal(t, http.StatusUnauthorized, doRequest(t, "DELETE", keyURL, "", "", nil).Code)
+		assert.Equal(t, http.StatusOK, doRequest(t, "DELETE", keyURL, testAPIKey, "", nil).Code)
+		assert.Equal(t, http.StatusNotFound, doRequest(t, "DELETE", keyURL, testAPIKey, "", nil).Code)
+		assert.Equal(t, http.StatusNotFound, doRequest(t, "GET", "/pks/lookup?op=get&search=one@example.com", "", "", nil).Code)
+
+		w := doFormRequest(t, "POST", "/pks/add", testAPIKey, "keytext="+url.QueryEscape(testKeyring))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		results := []models.KeyImportResult{}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&results))
+		assert.Equal(t, models.KeyImportResult{Fingerprint: "70e2ec2c7f2926afd93559e499847344d7d73b58", Status: models.KeyRejected, Reason: "key has been deleted"}, results[0])
+		assert.Equal(t, http.StatusNotFound, doRequest(t, "GET", "/pks/lookup?op=get&search=one@example.com", "", "", nil).Code)
+	})
+}
+
+func TestGPGKeyExpiry(t *testing.T) {
+	assert.Equal(t, http.StatusOK, doFormRequest(t, "POST", "/pks/add", testAPIKey, "keytext="+url.QueryEscape(expiredTestKey)).Code)
+
+	expiresAt := time.Date(2024, 1, 3, 3, 4, 5, 0, time.UTC)
+	assertExpired := func() {
+		key := models.GPGPubKeyStore{}
+		assert.NoError(t, app.DB.Where("fingerprint = ?", "637bfaff3e2952e7e55e0073407bd70abff43c6c").First(&key).Error)
+		assert.True(t, key.Expired)
+		if assert.NotNil(t, key.ExpiresAt) {
+			assert.True(t, expiresAt.Equal(*key.ExpiresAt))
+		}
+	}
+	assertExpired()
+
+	t.Run("Index", func(t *testing.T) {
+		w := doRequest(t, "GET", "/pks/lookup?op=index&options=mr&search=expired@example.com", "", "", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		lines := strings.Split(w.Body.String(), "\n")
+		assert.True(t, strings.HasPrefix(lines[1], "pub:637BFAFF3E2952E7E55E0073407BD70ABFF43C6C:"))
+		assert.True(t, strings.HasSuffix(lines[1], fmt.Sprintf(":%d:e", expiresAt.Unix())))
+
+		w = doRequest(t, "GET", "/pks/lookup?op=index&search=expired@example.com", "", "", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "[expires: 2024-01-03] [expired]")
+	})
+
+	t.Run("Hide expired", func(t *testing.T) {
+		config.Current.GPG.HideExpired = true
+		defer func() { config.Current.GPG.HideExpired = false }()
+
+		assert.Equal(t, http.StatusNotFound, doRequest(t, "GET", "/pks/lookup?op=get&search=expired@example.com", "", "", nil).Code)
+		assert.Equal(t, http.StatusNotFound, doRequest(t, "GET", "/pks/lookup?op=index&search=expired", "", "", nil).Code)
+		assert.Equal(t, http.StatusOK, doRequest(t, "GET", "/pks/lookup?op=get&search=0x637BFAFF3E2952E7E55E0073407BD70ABFF43C6C", "", "", nil).Code)
+		assert.Equal(t, http.StatusOK, doRequest(t, "GET", "/pks/lookup?op=get&search=example@example.com", "", "", nil).Code)
+	})
+
+	t.Run("Refresh", func(t *testing.T) {
+		assert.NoError(t, app.DB.Model(&models.GPGPubKeyStore{}).Where("fingerprint = ?", "637bfaff3e2952e7e55e0073407bd70abff43c6c").
+			UpdateColumns(map[string]any{"expired": false, "expires_at": nil}).Error)
+
+		changed, err := models.RefreshKeyStatus(app.DB, time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, 1, changed)
+		assertExpired()
+
+		changed, err = models.RefreshKeyStatus(app.DB, time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, 0, changed)
+	})
+}
+
+func TestGPGKeyAlgorithm(t *testing.T) {
+	assertAlgorithms := func() {
+		key := models.GPGPubKeyStore{}
+		assert.NoError(t, app.DB.Preload("Subkeys").Where("fingerprint = ?", "637bfaff3e2952e7e55e0073407bd70abff43c6c").First(&key).Error)
+		assert.Equal(t, "EdDSA", key.Algorithm)
+		assert.Equal(t, 255, key.BitLength)
+		assert.Equal(t, "ed25519", key.Curve)
+		assert.Equal(t, "1.3.6.1.4.1.11591.15.1", key.CurveOID)
+		if assert.Len(t, key.Subkeys, 1) {
+			assert.Equal(t, "ECDH", key.Subkeys[0].Algorithm)
+			assert.Equal(t, 255, key.Subkeys[0].BitLength)
+			assert.Equal(t, "cv25519", key.Subkeys[0].Curve)
+			assert.Equal(t, "1.3.6.1.4.1.3029.1.5.1", key.Subkeys[0].CurveOID)
+		}
+
+		key = models.GPGPubKeyStore{}
+		assert.NoError(t, app.DB.Where("fingerprint = ?", "22a37a9a70e3965157e16007fe066b04b44da0d3").First(&key).Error)
+		assert.Equal(t, "RSA", key.Algorithm)
+		assert.Equal(t, 4096, key.BitLength)
+		assert.Empty(t, key.Curve)
+		assert.Empty(t, key.CurveOID)
+	}
+	assertAlgorithms()
+
+	t.Run("Index", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r, err := http.NewRequest("GET", "/pks/lookup?op=index&options=mr&search=0x637BFAFF3E2952E7E55E0073407BD70ABFF43C6C", nil)
+		assert.NoError(t, err)
+		app.Router.ServeHTTP(w, r)
+		assert.Contains(t, w.Body.String(), "pub:637BFAFF3E2952E7E55E0073407BD70ABFF43C6C:22:255:")
+
+		w = httptest.NewRecorder()
+		r, err = http.NewRequest("GET", "/pks/lookup?op=index&search=0x637BFAFF3E2952E7E55E0073407BD70ABFF43C6C", nil)
+		assert.NoError(t, err)
+		app.Router.ServeHTTP(w, r)
+		assert.Contains(t, w.Body.String(), "pub  ed25519/")
+		assert.Contains(t, w.Body.String(), "sub  cv25519/")
+	})
+
+	t.Run("Backfill", func(t *testing.T) {
+		// Keys used to store the algorithm ID converted to a rune.
+		assert.NoError(t, app.DB.Unscoped().Model(&models.GPGPubKeyStore{}).Where("1 = 1").
+			UpdateColumns(map[string]any{"algorithm": string(rune(22)), "bit_length": 0, "curve": "", "curve_oid": ""}).Error)
+		assert.NoError(t, app.DB.Where("id = ?", "0003_gpg_key_algorithms").Delete(&models.SchemaMigration{}).Error)
+
+		assert.NoError(t, models.Migrate(app.DB))
+		assertAlgorithms()
+	})
+}
+
+func TestGPGKeyBinary(t *testing.T) {
+	block, err := armor.Decode(strings.NewReader(binaryTestKey))
+	assert.NoError(t, err)
+	binaryKey, err := io.ReadAll(block.Body)
+	assert.NoError(t, err)
+
+	addKey := func(contentType string, body []byte) *httptest.ResponseRecorder {
+		return doRequest(t, "POST", "/pks/add", testAPIKey, contentType, bytes.NewReader(body))
+	}
+
+	t.Run("Upload", func(t *testing.T) {
+		testCases := []struct {
+			name        string
+			contentType string
+			body        []byte
+			status      models.KeyImportStatus
+		}{
+			{"Binary", handler.ContentTypePGPKeys, binaryKey, models.KeyAdded},
+			{"Octet stream", handler.ContentTypeOctetStream, binaryKey, models.KeyUnchanged},
+			{"Armored body", handler.ContentTypePGPKeys, []byte(binaryTestKey), models.KeyUnchanged},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				w := addKey(tc.contentType, tc.body)
+				assert.Equal(t, http.StatusOK, w.Code)
+
+				results := []models.KeyImportResult{}
+				assert.NoError(t, json.NewDecoder(w.Body).Decode(&results))
+				assert.Equal(t, []models.KeyImportResult{{Fingerprint: "645ceccb9e1665d41978add2602dba0a0cc0a5e6", Status: tc.status}}, results)
+			})
+		}
+
+		assert.Equal(t, http.StatusBadRequest, addKey(handler.ContentTypePGPKeys, []byte("not a key")).Code)
+	})
+
+	t.Run("Get", func(t *testing.T) {
+		testCases := []struct {
+			name        string
+			accept      string
+			contentType string
+		}{
+			{"Default", "", "text/plain"},
+			{"Text", "text/plain", "text/plain"},
+			{"Any", "*/*", "text/plain"},
+			{"PGP keys", "application/pgp-keys", handler.ContentTypePGPKeys},
+			{"Octet stream", "application/octet-stream, text/plain;q=0.5", handler.ContentTypeOctetStream},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				w := httptest.NewRecorder()
+				r, err := http.NewRequest("GET", "/pks/lookup?op=get&search=binary@example.com", nil)
+				assert.NoError(t, err)
+				if tc.accept != "" {
+					r.Header.Set("Accept", tc.accept)
+				}
+				app.Router.ServeHTTP(w, r)
+				assert.Equal(t, http.StatusOK, w.Code)
+				assert.Equal(t, tc.contentType, w.Header().Get("Content-Type"))
+				assert.Equal(t, "Accept", w.Header().Get("Vary"))
+
+				var entities openpgp.EntityList
+				if tc.contentType == "text/plain" {
+					entities, err = openpgp.ReadArmoredKeyRing(w.Body)
+				} else {
+					assert.Equal(t, binaryKey, w.Body.Bytes())
+					entities, err = openpgp.ReadKeyRing(w.Body)
+				}
+				assert.NoError(t, err)
+				if assert.Len(t, entities, 1) {
+					assert.Equal(t, "645CECCB9E1665D41978ADD2602DBA0A0CC0A5E6", fmt.Sprintf("%X", entities[0].PrimaryKey.Fingerprint))
+				}
+			})
+		}
+	})
+}
+
+func TestKeysAPI(t *testing.T) {
+	listKeys := func(query string) handler.KeyListResponse {
+		w := doJSONRequest(t, "GET", "/api/v1/keys?"+query, testAPIKey, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		resp := handler.KeyListResponse{}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		return resp
+	}
+	fingerprints := func(keys []handler.KeyResponse) []string {
+		fprs := []string{}
+		for _, key := range keys {
+			fprs = append(fprs, key.Fingerprint)
+		}
+		return fprs
+	}
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, doJSONRequest(t, "GET", "/api/v1/keys", "", nil).Code)
+	})
+
+	t.Run("Upload", func(t *testing.T) {
+		w := doJSONRequest(t, "POST", "/api/v1/keys", testAPIKey, handler.KeyUploadPayload{KeyText: apiTestKey})
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		results := []models.KeyImportResult{}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&results))
+		assert.Equal(t, []models.KeyImportResult{{Fingerprint: "c4893f11683d054219bf3b5529603c165bd5c68b", Status: models.KeyAdded}}, results)
+
+		assert.Equal(t, http.StatusBadRequest, doJSONRequest(t, "POST", "/api/v1/keys", testAPIKey, handler.KeyUploadPayload{}).Code)
+		assert.Equal(t, http.StatusBadRequest, doJSONRequest(t, "POST", "/api/v1/keys", testAPIKey, "null").Code)
+		assert.Equal(t, http.StatusBadRequest, doJSONRequest(t, "POST", "/api/v1/keys", testAPIKey, handler.KeyUploadPayload{KeyText: "not a key"}).Code)
+	})
+
+	t.Run("Get", func(t *testing.T) {
+		w := doJSONRequest(t, "GET", "/api/v1/keys/0x22A37A9A70E3965157E16007FE066B04B44DA0D3", testAPIKey, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		key := handler.KeyResponse{}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&key))
+		assert.Equal(t, "22a37a9a70e3965157e16007fe066b04b44da0d3", key.Fingerprint)
+		assert.Equal(t, "RSA", key.Algorithm)
+		assert.Equal(t, 4096, key.BitLength)
+		assert.False(t, key.Revoked)
+		if assert.Len(t, key.Users, 1) {
+			assert.Equal(t, "example@example.com", key.Users[0].Email)
+			assert.True(t, key.Users[0].Primary)
+		}
+		if assert.Len(t, key.Subkeys, 1) {
+			assert.Equal(t, "e475c00aedaacb258e273671beec894148003b9a", key.Subkeys[0].Fingerprint)
+		}
+
+		assert.Equal(t, http.StatusOK, doJSONRequest(t, "GET", "/api/v1/keys/BEEC894148003B9A", testAPIKey, nil).Code)
+		assert.Equal(t, http.StatusOK, doJSONRequest(t, "GET", "/api/v1/keys/api@example.com", testAPIKey, nil).Code)
+		assert.Equal(t, http.StatusConflict, doJSONRequest(t, "GET", "/api/v1/keys/example@example.com", testAPIKey, nil).Code)
+		assert.Equal(t, http.StatusNotFound, doJSONRequest(t, "GET", "/api/v1/keys/nobody@example.com", testAPIKey, nil).Code)
+	})
+
+	t.Run("List", func(t *testing.T) {
+		all := listKeys("")
+		assert.Empty(t, all.NextCursor)
+		assert.Greater(t, len(all.Keys), 3)
+		assert.IsIncreasing(t, fingerprints(all.Keys))
+
+		paged := []handler.KeyResponse{}
+		cursor := ""
+		for {
+			page := listKeys("limit=2&cursor=" + cursor)
+			assert.LessOrEqual(t, len(page.Keys), 2)
+			paged = append(paged, page.Keys...)
+			if page.NextCursor == "" {
+				break
+			}
+			cursor = page.NextCursor
+		}
+		assert.Equal(t, fingerprints(all.Keys), fingerprints(paged))
+
+		assert.ElementsMatch(t, []string{"22a37a9a70e3965157e16007fe066b04b44da0d3", "ff81f4cb6702d3218759dca365607c5b5a86e5cc"}, fingerprints(listKeys("email=Example@example.com").Keys))
+		assert.Equal(t, []string{"ff81f4cb6702d3218759dca365607c5b5a86e5cc"}, fingerprints(listKeys("revoked=true").Keys))
+		assert.Equal(t, []string{"637bfaff3e2952e7e55e0073407bd70abff43c6c"}, fingerprints(listKeys("expired=true").Keys))
+		assert.Empty(t, listKeys("domain=example.in").Keys)
+		assert.Len(t, listKeys("expired=false").Keys, len(all.Keys)-1)
+
+		assert.Equal(t, http.StatusBadRequest, doJSONRequest(t, "GET", "/api/v1/keys?limit=1000", testAPIKey, nil).Code)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		// A search matching several keys deletes none of them.
+		assert.Equal(t, http.StatusConflict, doJSONRequest(t, "DELETE", "/api/v1/keys/example@example.com", testAPIKey, nil).Code)
+		assert.Equal(t, http.StatusOK, doJSONRequest(t, "GET", "/api/v1/keys/0x22A37A9A70E3965157E16007FE066B04B44DA0D3", testAPIKey, nil).Code)
+
+		keyURL := "/api/v1/keys/0xC4893F11683D054219BF3B5529603C165BD5C68B"
+		assert.Equal(t, http.StatusOK, doJSONRequest(t, "DELETE", "/api/v1/keys/api@example.com", testAPIKey, nil).Code)
+		assert.Equal(t, http.StatusNotFound, doJSONRequest(t, "DELETE", keyURL, testAPIKey, nil).Code)
+		assert.Equal(t, http.StatusNotFound, doJSONRequest(t, "GET", keyURL, testAPIKey, nil).Code)
+	})
+}
+
+func TestVKS(t *testing.T) {
+	verificationConfig, publicURL := config.Current.Verification, config.Current.Server.PublicURL
+	defer func() {
+		config.Current.Verification, config.Current.Server.PublicURL = verificationConfig, publicURL
+	}()
+	config.Current.Verification = config.VerificationConfig{Enabled: true, Secret: "test-secret", TokenTTL: time.Hour}
+	config.Current.Server.PublicURL = "https://keys.example.com"
+
+	outbox := app.Mailer.(*mailer.MemoryMailer)
+	outbox.Reset()
+
+	decode := func(w *httptest.ResponseRecorder) handler.VKSUploadResponse {
+		assert.Equal(t, http.StatusOK, w.Code)
+		resp := handler.VKSUploadResponse{}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		return resp
+	}
+
+	// Uploads need no API key while email verification gates publication.
+	upload := decode(doJSONRequest(t, "POST", "/vks/v1/upload", "", handler.VKSUploadPayload{KeyText: vksTestKey}))
+	assert.NotEmpty(t, upload.Token)
+	assert.Equal(t, "521496AC1A2208FD8C6313FB30926A1E9F378FB4", upload.KeyFpr)
+	assert.Equal(t, map[string]string{"vks@example.com": handler.VKSStatusUnpublished}, upload.Status)
+	assert.Empty(t, outbox.Messages())
+
+	t.Run("Lookup", func(t *testing.T) {
+		testCases := []struct {
+			name   string
+			url    string
+			status int
+		}{
+			{"By fingerprint", "/vks/v1/by-fingerprint/521496AC1A2208FD8C6313FB30926A1E9F378FB4", http.StatusOK},
+			{"By lowercase fingerprint", "/vks/v1/by-fingerprint/521496ac1a2208fd8c6313fb30926a1e9f378fb4", http.StatusOK},
+			{"By key ID", "/vks/v1/by-keyid/30926A1E9F378FB4", http.StatusOK},
+			{"By unverified email", "/vks/v1/by-email/vks%40example.com", http.StatusNotFound},
+			{"Unknown fingerprint", "/vks/v1/by-fingerprint/0000000000000000000000000000000000000000", http.StatusNotFound},
+			{"Invalid fingerprint", "/vks/v1/by-fingerprint/0x521496AC1A2208FD8C6313FB30926A1E9F378FB4", http.StatusBadRequest},
+			{"Invalid key ID", "/vks/v1/by-keyid/9F378FB4", http.StatusBadRequest},
+			{"Invalid email", "/vks/v1/by-email/vks", http.StatusBadRequest},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				w := doJSONRequest(t, "GET", tc.url, "", nil)
+				assert.Equal(t, tc.status, w.Code)
+				if tc.status != http.StatusOK {
+					assert.Contains(t, w.Body.String(), `"error"`)
+					return
+				}
+
+				// The address is not verified yet, so the key is served without user IDs.
+				assert.Equal(t, handler.ContentTypePGPKeys, w.Header().Get("Content-Type"))
+				block, err := armor.Decode(w.Body)
+				assert.NoError(t, err)
+				assert.Equal(t, openpgp.PublicKeyType, block.Type)
+			})
+		}
+	})
+
+	t.Run("Request verify", func(t *testing.T) {
+		verify := func(token string, addresses ...string) *httptest.ResponseRecorder {
+			return doJSONRequest(t, "POST", "/vks/v1/request-verify", "", handler.VKSVerifyPayload{Token: token, Addresses: addresses})
+		}
+
+		// An upload token does not verify anything itself.
+		assert.Equal(t, http.StatusBadRequest, doRequest(t, "GET", "/pks/verify?token="+url.QueryEscape(upload.Token), "", "", nil).Code)
+		assert.Equal(t, http.StatusBadRequest, doFormRequest(t, "POST", "/pks/verify", "", "token="+url.QueryEscape(upload.Token)).Code)
+
+		assert.Equal(t, http.StatusBadRequest, verify("invalid", "vks@example.com").Code)
+		assert.Equal(t, http.StatusBadRequest, verify(upload.Token, "other@example.com").Code)
+
+		resp := decode(verify(upload.Token, "VKS@example.com"))
+		assert.Equal(t, map[string]string{"vks@example.com": handler.VKSStatusPending}, resp.Status)
+
+		messages := outbox.Messages()
+		assert.Len(t, messages, 1)
+		link := regexp.MustCompile(`https://keys\.example\.com(/pks/verify\?token=(\S+))`).FindStringSubmatch(messages[0].Body)
+		assert.Len(t, link, 3)
+
+		// A verification link token is not an upload token.
+		linkToken, err := url.QueryUnescape(link[2])
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, verify(linkToken, "vks@example.com").Code)
+
+		assert.Equal(t, http.StatusOK, doFormRequest(t, "POST", "/pks/verify", "", "token="+link[2]).Code)
+		assert.Equal(t, http.StatusOK, doJSONRequest(t, "GET", "/vks/v1/by-email/vks%40example.com", "", nil).Code)
+
+		upload := decode(doJSONRequest(t, "POST", "/vks/v1/upload", "", handler.VKSUploadPayload{KeyText: vksTestKey}))
+		assert.Equal(t, map[string]string{"vks@example.com": handler.VKSStatusPublished}, upload.Status)
+	})
+
+	t.Run("Invalid upload", func(t *testing.T) {
+		w := doJSONRequest(t, "POST", "/vks/v1/upload", "", handler.VKSUploadPayload{KeyText: testKeyring})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "expected a single key")
+
+		assert.Equal(t, http.StatusBadRequest, doJSONRequest(t, "POST", "/vks/v1/upload", "", "null").Code)
+		assert.Equal(t, http.StatusBadRequest, doJSONRequest(t, "POST", "/vks/v1/request-verify", "", "null").Code)
+	})
+
+	t.Run("Verification disabled", func(t *testing.T) {
+		config.Current.Verification.Enabled = false
+		defer func() { config.Current.Verification.Enabled = true }()
+
+		// Without verification an upload would publish its user IDs, so it needs an API key.
+		assert.Equal(t, http.StatusUnauthorized, doJSONRequest(t, "POST", "/vks/v1/upload", "", handler.VKSUploadPayload{KeyText: vksTestKey}).Code)
+	})
+}
+
+func TestRateLimit(t *testing.T) {
+	h := RateLimit(2, time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	serve := func(remoteAddr string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/vks/v1/upload", nil)
+		r.RemoteAddr = remoteAddr
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, serve("192.0.2.1:1234").Code)
+	assert.Equal(t, http.StatusOK, serve("192.0.2.1:5678").Code)
+	w := serve("192.0.2.1:1234")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	// Other clients have their own budget.
+	assert.Equal(t, http.StatusOK, serve("192.0.2.2:1234").Code)
+}
+
+func TestRateLimitForwardedFor(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	h := RealIP(trusted)(RateLimit(2, time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+	serve := func(remoteAddr, forwardedFor string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/vks/v1/upload", nil)
+		r.RemoteAddr = remoteAddr
+		r.Header.Set("X-Forwarded-For", forwardedFor)
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("Untrusted client", func(t *testing.T) {
+		// A client sending a new address each time is still limited by its connection.
+		assert.Equal(t, http.StatusOK, serve("192.0.2.1:1234", "198.51.100.1").Code)
+		assert.Equal(t, http.StatusOK, serve("192.0.2.1:1234", "198.51.100.2").Code)
+		assert.Equal(t, http.StatusTooManyRequests, serve("192.0.2.1:1234", "198.51.100.3").Code)
+	})
+
+	t.Run("Trusted proxy", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve("10.0.0.1:1234", "203.0.113.1").Code)
+		assert.Equal(t, http.StatusOK, serve("10.0.0.2:1234", "203.0.113.1, 10.0.0.1").Code)
+		assert.Equal(t, http.StatusTooManyRequests, serve("10.0.0.1:1234", "203.0.113.1").Code)
+
+		// Addresses the client prepends itself are not believed.
+		assert.Equal(t, http.StatusTooManyRequests, serve("10.0.0.1:1234", "198.51.100.9, 203.0.113.1").Code)
+
+		// Clients behind the proxy have their own budget.
+		assert.Equal(t, http.StatusOK, serve("10.0.0.1:1234", "203.0.113.2").Code)
+	})
+}
+
+func TestUpstreamLookup(t *testing.T) {
+	requests := 0
+	keyserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch strings.ToLower(r.URL.Query().Get("search")) {
+		case "upstream@example.net", "0x85e6afe40192668a", "impostor@example.net", "nobody@example.com":
+			fmt.Fprint(w, upstreamTestKey)
+		case "garbage@example.net":
+			fmt.Fprint(w, "not a key")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer keyserver.Close()
+
+	upstreamConfig, keyservers := config.Current.Upstream, app.Upstream
+	defer func() {
+		config.Current.Upstream, app.Upstream = upstreamConfig, keyservers
+	}()
+	config.Current.Upstream = config.UpstreamConfig{HKPServers: []string{keyserver.URL}, Timeout: time.Second, CacheTTL: time.Hour, MissTTL: time.Minute}
+	var err error
+	app.Upstream, err = upstream.New(config.Current.Upstream)
+	assert.NoError(t, err)
+
+	lookup := func(search, accept string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, err := http.NewRequest("GET", "/pks/lookup?op=get&search="+url.QueryEscape(search), nil)
+		assert.NoError(t, err)
+		if accept != "" {
+			r.Header.Add("Accept", accept)
+		}
+		app.Router.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("Local key", func(t *testing.T) {
+		w := lookup("0x22A37A9A70E3965157E16007FE066B04B44DA0D3", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get(handler.HeaderUpstreamSource))
+		assert.Equal(t, 0, requests)
+	})
+
+	t.Run("Upstream key", func(t *testing.T) {
+		w := lookup("upstream@example.net", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, keyserver.URL, w.Header().Get(handler.HeaderUpstreamSource))
+		assert.Equal(t, "false", w.Header().Get(handler.HeaderAuthoritative))
+
+		entities, err := openpgp.ReadArmoredKeyRing(w.Body)
+		assert.NoError(t, err)
+		assert.Len(t, entities, 1)
+		assert.Equal(t, "00cba4a8f900f27411dff29785e6afe40192668a", fmt.Sprintf("%x", entities[0].PrimaryKey.Fingerprint))
+
+		// The key is cached apart from the local keys.
+		_, err = models.LookupPubKey(app.DB, "upstream@example.net")
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+		assert.Equal(t, 1, requests)
+	})
+
+	t.Run("Cached", func(t *testing.T) {
+		w := lookup("Upstream@Example.net", handler.ContentTypeOctetStream)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, handler.ContentTypeOctetStream, w.Header().Get("Content-Type"))
+		assert.Equal(t, "false", w.Header().Get(handler.HeaderAuthoritative))
+
+		entities, err := openpgp.ReadKeyRing(w.Body)
+		assert.NoError(t, err)
+		assert.Len(t, entities, 1)
+		assert.Equal(t, 1, requests)
+	})
+
+	t.Run("PGP keys", func(t *testing.T) {
+		// Local and upstream keys come in the same binary form.
+		for _, search := range []string{"0x22A37A9A70E3965157E16007FE066B04B44DA0D3", "upstream@example.net"} {
+			w := lookup(search, handler.ContentTypePGPKeys)
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, handler.ContentTypePGPKeys, w.Header().Get("Content-Type"))
+
+			entities, err := openpgp.ReadKeyRing(w.Body)
+			assert.NoError(t, err)
+			assert.Len(t, entities, 1)
+		}
+		assert.Equal(t, 1, requests)
+	})
+
+	t.Run("Expired cache", func(t *testing.T) {
+		purged, err := models.PurgeUpstreamKeys(app.DB, time.Now().Add(2*time.Hour))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), purged)
+
+		assert.Equal(t, http.StatusOK, lookup("upstream@example.net", "").Code)
+		assert.Equal(t, 2, requests)
+	})
+
+	t.Run("Key ID", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, lookup("0x85E6AFE40192668A", "").Code)
+		assert.Equal(t, 3, requests)
+	})
+
+	t.Run("Not found", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, lookup("nobody@example.net", "").Code)
+		assert.Equal(t, http.StatusNotFound, lookup("garbage@example.net", "").Code)
+		assert.Equal(t, 5, requests)
+
+		// Misses are remembered until the miss TTL passes.
+		assert.Equal(t, http.StatusNotFound, lookup("nobody@example.net", "").Code)
+		assert.Equal(t, http.StatusNotFound, lookup("garbage@example.net", "").Code)
+		assert.Equal(t, 5, requests)
+
+		_, err := models.PurgeUpstreamKeys(app.DB, time.Now().Add(2*time.Minute))
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, lookup("nobody@example.net", "").Code)
+		assert.Equal(t, 6, requests)
+	})
+
+	t.Run("Unrelated key", func(t *testing.T) {
+		// The keyserver answers with a key that has no user ID for the address.
+		assert.Equal(t, http.StatusNotFound, lookup("impostor@example.net", "").Code)
+		assert.Equal(t, 7, requests)
+	})
+
+	t.Run("Owned domain", func(t *testing.T) {
+		// Addresses in the configured domains are never looked up elsewhere.
+		assert.Equal(t, http.StatusNotFound, lookup("nobody@example.com", "").Code)
+		assert.Equal(t, 7, requests)
+	})
+
+	t.Run("Upstream down", func(t *testing.T) {
+		down, err := upstream.NewHKPServer("http://127.0.0.1:1", &http.Client{Timeout: time.Second})
+		assert.NoError(t, err)
+		app.Upstream = upstream.NewClient(down)
+
+		assert.Equal(t, http.StatusBadGateway, lookup("down@example.net", "").Code)
+	})
+}
+
+func TestReplication(t *testing.T) {
+	settle := config.Current.Replication.Settle
+	defer func() {
+		config.Current.Replication.Settle = settle
+	}()
+	config.Current.Replication.Settle = 0
+
+	readFeed := func(cursor string, limit int) replication.ChangeFeed {
+		w := doJSONRequest(t, "GET", fmt.Sprintf("%s?cursor=%s&limit=%d", replication.FeedPath, url.QueryEscape(cursor), limit), testReplicationToken, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		feed := replication.ChangeFeed{}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&feed))
+		return feed
+	}
+	syncer := func(peerURL string) (*replication.Syncer, *replication.Peer) {
+		peer := replication.NewPeer(config.ReplicationPeer{Name: "peer", URL: peerURL, Token: testReplicationToken}, time.Second)
+		return replication.NewSyncer(app.DB, peer), peer
+	}
+
+	t.Run("Feed", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, doJSONRequest(t, "GET", replication.FeedPath, "", nil).Code)
+		assert.Equal(t, http.StatusBadRequest, doJSONRequest(t, "GET", replication.FeedPath+"?cursor=bogus", testReplicationToken, nil).Code)
+		assert.Equal(t, http.StatusBadRequest, doJSONRequest(t, "GET", replication.FeedPath+"?limit=1000", testReplicationToken, nil).Code)
+
+		// API keys do not open the feed, and replication tokens open nothing else.
+		assert.Equal(t, http.StatusUnauthorized, doJSONRequest(t, "GET", replication.FeedPath, testAPIKey, nil).Code)
+		assert.Equal(t, http.StatusUnauthorized, doJSONRequest(t, "GET", "/api/v1/keys", testReplicationToken, nil).Code)
+		assert.Equal(t, http.StatusUnauthorized, doJSONRequest(t, "DELETE", "/api/v1/keys/example@example.com", testReplicationToken, nil).Code)
+
+		var stored int64
+		assert.NoError(t, app.DB.Unscoped().Model(&models.GPGPubKeyStore{}).Count(&stored).Error)
+
+		changes := []models.KeyChange{}
+		feed := replication.ChangeFeed{More: true}
+		for feed.More {
+			feed = readFeed(feed.NextCursor, 2)
+			changes = append(changes, feed.Changes...)
+		}
+		assert.Len(t, changes, int(stored))
+		assert.Empty(t, readFeed(feed.NextCursor, 2).Changes)
+
+		deleted := map[string]bool{}
+		for _, change := range changes {
+			deleted[change.Fingerprint] = change.DeletedAt != nil
+		}
+		assert.True(t, deleted["70e2ec2c7f2926afd93559e499847344d7d73b58"])
+		assert.False(t, deleted["22a37a9a70e3965157e16007fe066b04b44da0d3"])
+	})
+
+	t.Run("Own feed", func(t *testing.T) {
+		// Pulling changes that are already stored must not change anything, or peers would
+		// keep echoing them back and forth.
+		self := httptest.NewServer(app.Router)
+		defer self.Close()
+
+		_, head, _, err := models.ListKeyChanges(app.DB, "", 1000, time.Now())
+		assert.NoError(t, err)
+
+		s, peer := syncer(self.URL)
+		assert.NoError(t, s.SyncPeer(context.Background(), peer))
+
+		changes, _, _, err := models.ListKeyChanges(app.DB, head, 1000, time.Now())
+		assert.NoError(t, err)
+		assert.Empty(t, changes)
+		assert.NoError(t, app.DB.Where("peer = ?", "peer").Delete(&models.ReplicationState{}).Error)
+	})
+
+	t.Run("Pull", func(t *testing.T) {
+		deletedKey := models.GPGPubKeyStore{}
+		assert.NoError(t, app.DB.Unscoped().Where("fingerprint = ?", "70e2ec2c7f2926afd93559e499847344d7d73b58").First(&deletedKey).Error)
+
+		changedAt := time.Now()
+		feeds := map[string]replication.ChangeFeed{
+			"": {NextCursor: "c1", More: true, Changes: []models.KeyChange{
+				{Fingerprint: "55D7EC4B73FBEFB3CC087B335F6DDF0B82B3CC58", PublicKey: replicatedTestKey, VerifiedEmails: []string{"replicated@example.com", "other@example.com"}, UpdatedAt: changedAt},
+				{Fingerprint: "521496ac1a2208fd8c6313fb30926a1e9f378fb4", PublicKey: vksTestKey, UpdatedAt: changedAt, DeletedAt: &changedAt},
+			}},
+			"c1": {NextCursor: "c2", Changes: []models.KeyChange{
+				{Fingerprint: "70e2ec2c7f2926afd93559e499847344d7d73b58", PublicKey: deletedKey.PublicKey, UpdatedAt: changedAt},
+				{Fingerprint: "0000000000000000000000000000000000000000", PublicKey: replicatedTestKey, UpdatedAt: changedAt},
+			}},
+			"c2": {NextCursor: "c2", Changes: []models.KeyChange{}},
+		}
+		peerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get(commonMiddleware.AuthHeaderName) != testReplicationToken || r.URL.Path != replication.FeedPath {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			json.NewEncoder(w).Encode(feeds[r.URL.Query().Get("cursor")])
+		}))
+		defer peerServer.Close()
+
+		s, peer := syncer(peerServer.URL)
+		replicationSyncer := app.Replication
+		defer func() {
+			app.Replication = replicationSyncer
+		}()
+		app.Replication = s
+
+		assert.NoError(t, s.SyncPeer(context.Background(), peer))
+
+		keys, err := models.LookupPubKey(app.DB, "replicated@example.com")
+		assert.NoError(t, err)
+		assert.Len(t, keys, 1)
+		assert.Equal(t, []string{"replicated@example.com"}, []string{keys[0].VerifiedEmails[0].Email})
+
+		// The tombstone wins over the live key, and the deleted key stays deleted.
+		_, err = models.LookupPubKey(app.DB, "0x521496AC1A2208FD8C6313FB30926A1E9F378FB4")
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+		_, err = models.LookupPubKey(app.DB, "0x70E2EC2C7F2926AFD93559E499847344D7D73B58")
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+		// A second pull resumes from the stored cursor.
+		assert.NoError(t, s.SyncPeer(context.Background(), peer))
+
+		w := doJSONRequest(t, "GET", "/api/v1/replication/status", testAPIKey, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		status := handler.ReplicationStatusResponse{}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&status))
+		if assert.Len(t, status.Peers, 1) {
+			assert.Equal(t, "peer", status.Peers[0].Name)
+			assert.Equal(t, "c2", status.Peers[0].State.Cursor)
+			assert.Equal(t, int64(4), status.Peers[0].State.Applied)
+			assert.Empty(t, status.Peers[0].State.LastError)
+			assert.NotNil(t, status.Peers[0].Lag)
+		}
+
+		peerServer.Close()
+		assert.Error(t, s.SyncPeer(context.Background(), peer))
+
+		statuses, err := s.Status(time.Now())
+		assert.NoError(t, err)
+		assert.NotEmpty(t, statuses[0].State.LastError)
+		assert.Equal(t, "c2", statuses[0].State.Cursor)
+	})
+
+	t.Run("Deletion", func(t *testing.T) {
+		_, cursor, _, err := models.ListKeyChanges(app.DB, "", 1000, time.Now())
+		assert.NoError(t, err)
+
+		// Deleting moves the key to the end of the feed as a tombstone.
+		assert.NoError(t, models.DeletePubKey(app.DB, "55d7ec4b73fbefb3cc087b335f6ddf0b82b3cc58"))
+		changes, _, _, err := models.ListKeyChanges(app.DB, cursor, 1000, time.Now())
+		assert.NoError(t, err)
+		if assert.Len(t, changes, 1) {
+			assert.Equal(t, "55d7ec4b73fbefb3cc087b335f6ddf0b82b3cc58", changes[0].Fingerprint)
+			assert.NotNil(t, changes[0].DeletedAt)
+		}
+	})
+
+	t.Run("Restore", func(t *testing.T) {
+		refuseDeletedKeys := config.Current.GPG.RefuseDeletedKeys
+		defer func() {
+			config.Current.GPG.RefuseDeletedKeys = refuseDeletedKeys
+		}()
+		config.Current.GPG.RefuseDeletedKeys = false
+
+		// A live copy only restores a tombstone when it was restored after that deletion,
+		// however recent its timestamp.
+		future := time.Now().Add(time.Hour)
+		change := models.KeyChange{Fingerprint: "55d7ec4b73fbefb3cc087b335f6ddf0b82b3cc58", PublicKey: replicatedTestKey, UpdatedAt: future}
+		status, _, err := models.ApplyKeyChange(app.DB, &change)
+		assert.NoError(t, err)
+		assert.Equal(t, models.KeyUnchanged, status)
+		_, err = models.LookupPubKey(app.DB, "replicated@example.com")
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+		change.Generation = 1
+		status, _, err = models.ApplyKeyChange(app.DB, &change)
+		assert.NoError(t, err)
+		assert.Equal(t, models.KeyUpdated, status)
+		_, err = models.LookupPubKey(app.DB, "replicated@example.com")
+		assert.NoError(t, err)
+
+		// A deletion from before the restore is stale.
+		deletedAt := time.Now()
+		change = models.KeyChange{Fingerprint: "55d7ec4b73fbefb3cc087b335f6ddf0b82b3cc58", PublicKey: replicatedTestKey, UpdatedAt: deletedAt, DeletedAt: &deletedAt}
+		status, _, err = models.ApplyKeyChange(app.DB, &change)
+		assert.NoError(t, err)
+		assert.Equal(t, models.KeyUnchanged, status)
+
+		change.Generation = 1
+		status, _, err = models.ApplyKeyChange(app.DB, &change)
+		assert.NoError(t, err)
+		assert.Equal(t, models.KeyDeleted, status)
+	})
+
+	t.Run("Deletion under UID policy", func(t *testing.T) {
+		uidPolicy := config.Current.GPG.UIDPolicy
+		defer func() {
+			config.Current.GPG.UIDPolicy = uidPolicy
+		}()
+		config.Current.GPG.UIDPolicy = constants.UIDPolicyStrip
+
+		// The key has no user ID in the configured domains, yet its tombstone is kept.
+		parsed, err := models.ParsePubKeys(foreignTestKey)
+		assert.NoError(t, err)
+		deletedAt := time.Now()
+		change := models.KeyChange{Fingerprint: parsed[0].Fingerprint, PublicKey: foreignTestKey, UpdatedAt: deletedAt, DeletedAt: &deletedAt}
+
+		status, _, err := models.ApplyKeyChange(app.DB, &change)
+		assert.NoError(t, err)
+		assert.Equal(t, models.KeyDeleted, status)
+
+		tombstone := models.GPGPubKeyStore{}
+		assert.NoError(t, app.DB.Unscoped().Where("fingerprint = ?", change.Fingerprint).First(&tombstone).Error)
+		assert.True(t, tombstone.DeletedAt.Valid)
+		assert.NoError(t, app.DB.Unscoped().Delete(&tombstone).Error)
+	})
+
+	t.Run("Settle", func(t *testing.T) {
+		_, cursor, _, err := models.ListKeyChanges(app.DB, "", 1000, time.Now())
+		assert.NoError(t, err)
+
+		// Stamp the changes as if made an hour from now, after everything served so far.
+		now := time.Now().Add(time.Hour)
+
+		// A change stamped later commits first. The feed holds it back instead of moving
+		// the cursor past the change stamped before it, which has not committed yet.
+		later := models.GPGPubKeyStore{}
+		assert.NoError(t, app.DB.Where("fingerprint = ?", "22a37a9a70e3965157e16007fe066b04b44da0d3").First(&later).Error)
+		assert.NoError(t, app.DB.Model(&later).UpdateColumn("updated_at", now.Add(-time.Second)).Error)
+
+		changes, next, _, err := models.ListKeyChanges(app.DB, cursor, 1000, now.Add(-time.Minute))
+		assert.NoError(t, err)
+		assert.Empty(t, changes)
+		assert.Equal(t, cursor, next)
+
+		config.Current.Replication.Settle = time.Minute
+		feed := readFeed(cursor, 100)
+		assert.Empty(t, feed.Changes)
+		assert.Equal(t, cursor, feed.NextCursor)
+
+		earlier := models.GPGPubKeyStore{}
+		assert.NoError(t, app.DB.Where("fingerprint = ?", "637bfaff3e2952e7e55e0073407bd70abff43c6c").First(&earlier).Error)
+		assert.NoError(t, app.DB.Model(&earlier).UpdateColumn("updated_at", now.Add(-2*time.Second)).Error)
+
+		// Once both have settled, both are served in order.
+		changes, _, _, err = models.ListKeyChanges(app.DB, cursor, 1000, now.Add(time.Minute))
+		assert.NoError(t, err)
+		fingerprints := []string{}
+		for _, change := range changes {
+			fingerprints = append(fingerprints, change.Fingerprint)
+		}
+		assert.Equal(t, []string{earlier.Fingerprint, later.Fingerprint}, fingerprints)
+
+		// Put the keys back in the present for the tests that follow.
+		assert.NoError(t, app.DB.Model(&models.GPGPubKeyStore{}).Where("key_id IN ?", []string{later.KeyID, earlier.KeyID}).UpdateColumn("updated_at", time.Now()).Error)
+	})
+}
+
+func TestGPGUIDPolicy(t *testing.T) {
+	uidPolicy := config.Current.GPG.UIDPolicy
+	defer func() {
+		config.Current.GPG.UIDPolicy = uidPolicy
+	}()
+	config.Current.GPG.UIDPolicy = constants.UIDPolicyStrip
+
+	addKey := func(keyText string) (int, models.KeyImportResult) {
+		w := doFormRequest(t, "POST", "/pks/add", testAPIKey, "keytext="+url.QueryEscape(keyText))
+
+		results := []models.KeyImportResult{}
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&results))
+		assert.Len(t, results, 1)
+		return w.Code, results[0]
+	}
+
+	t.Run("Strip", func(t *testing.T) {
+		status, result := addKey(policyTestKey)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, models.KeyAdded, result.Status)
+		assert.ElementsMatch(t, []string{"Policy <policy@gmail.com>", "Policy Nomail"}, result.Dropped)
+
+		keys, err := models.LookupPubKey(app.DB, "policy@example.com")
+		assert.NoError(t, err)
+		if assert.Len(t, keys, 1) {
+			assertUsers(t, keys[0].KeyID, "policy@example.com")
+		}
+		_, err = models.LookupPubKey(app.DB, "policy@gmail.com")
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+		// Uploading it again strips the same user IDs and changes nothing.
+		_, result = addKey(policyTestKey)
+		assert.Equal(t, models.KeyUnchanged, result.Status)
+		assert.Len(t, result.Dropped, 2)
+	})
+
+	t.Run("Nothing left", func(t *testing.T) {
+		status, result := addKey(foreignTestKey)
+		assert.Equal(t, http.StatusBadRequest, status)
+		assert.Equal(t, models.KeyRejected, result.Status)
+		assert.Equal(t, "no user ID in the configured domains", result.Reason)
+		assert.Empty(t, result.Dropped)
+
+		_, err := models.LookupPubKey(app.DB, "foreign@gmail.com")
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	})
+
+	t.Run("Accept", func(t *testing.T) {
+		config.Current.GPG.UIDPolicy = constants.UIDPolicyAccept
+
+		status, result := addKey(foreignTestKey)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, models.KeyAdded, result.Status)
+		assert.Empty(t, result.Dropped)
+	})
+
+	t.Run("Refresh without owned user IDs", func(t *testing.T) {
+		keys, err := models.LookupPubKey(app.DB, "foreign@gmail.com")
+		assert.NoError(t, err)
+		if !assert.Len(t, keys, 1) {
+			return
+		}
+		keyID := keys[0].KeyID
+
+		// A stale status makes the refresh want to save the key stored under the accept policy.
+		assert.NoError(t, app.DB.Model(&models.GPGPubKeyStore{}).Where("key_id = ?", keyID).Update("revoked", true).Error)
+
+		config.Current.GPG.UIDPolicy = constants.UIDPolicyStrip
+		defer func() {
+			config.Current.GPG.UIDPolicy = constants.UIDPolicyAccept
+		}()
+		_, err = models.RefreshKeyStatus(app.DB, time.Now())
+		assert.NoError(t, err)
+
+		// The key is left alone rather than saved with its foreign user ID.
+		key := models.GPGPubKeyStore{}
+		assert.NoError(t, app.DB.Where("key_id = ?", keyID).First(&key).Error)
+		assert.True(t, key.Revoked)
+		assertUsers(t, keyID, "foreign@gmail.com")
+	})
+
+	legacyKeyID := func() string {
+		keys, err := models.LookupPubKey(app.DB, "legacy@example.com")
+		assert.NoError(t, err)
+		if !assert.Len(t, keys, 1) {
+			return ""
+		}
+		return keys[0].KeyID
+	}
+
+	t.Run("Stored foreign user IDs", func(t *testing.T) {
+		config.Current.GPG.UIDPolicy = constants.UIDPolicyAccept
+		_, result := addKey(legacyTestKey)
+		assert.Equal(t, models.KeyAdded, result.Status)
+		assertUsers(t, legacyKeyID(), "legacy@example.com", "legacy@gmail.com")
+
+		// The upload only carries the owned user ID, but the stored foreign one must not
+		// survive the merge.
+		config.Current.GPG.UIDPolicy = constants.UIDPolicyStrip
+		_, result = addKey(legacyTestKey)
+		assert.Equal(t, models.KeyUpdated, result.Status)
+		assertUsers(t, legacyKeyID(), "legacy@example.com")
+
+		_, err := models.LookupPubKey(app.DB, "legacy@gmail.com")
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	})
+
+	t.Run("Enforce on stored keys", func(t *testing.T) {
+		config.Current.GPG.UIDPolicy = constants.UIDPolicyAccept
+		_, result := addKey(legacyTestKey)
+		assert.Equal(t, models.KeyUpdated, result.Status)
+		keyID := legacyKeyID()
+		assertUsers(t, keyID, "legacy@example.com", "legacy@gmail.com")
+
+		config.Current.GPG.UIDPolicy = constants.UIDPolicyStrip
+		changed, err := models.EnforceUIDPolicy(app.DB)
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, changed, 2)
+		assertUsers(t, keyID, "legacy@example.com")
+
+		// The key stored under the accept policy has nothing left and is removed.
+		_, err = models.LookupPubKey(app.DB, "foreign@gmail.com")
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+		changed, err = models.EnforceUIDPolicy(app.DB)
+		assert.NoError(t, err)
+		assert.Zero(t, changed)
+	})
+}
+
+func TestPublicCORS(t *testing.T) {
+	t.Run("Simple request", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r, err := http.NewRequest("GET", "/.well-known/webfinger?resource=acct:test@example.com", nil)
+		assert.NoError(t, err)
+		r.Header.Set("Origin", "https://client.example.org")
+		app.Router.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("Preflight request", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r, err := http.NewRequest("OPTIONS", "/pks/lookup", nil)
+		assert.NoError(t, err)
+		r.Header.Set("Origin", "https://client.example.org")
+		r.Header.Set("Access-Control-Request-Method", "GET")
+		app.Router.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "GET, HEAD, OPTIONS", w.Header().Get("Access-Control-Allow-Methods"))
+	})
+}
+
+func TestConditionalGet(t *testing.T) {
+	testCases := []struct {
+		Name string
+		URL  string
+	}{
+		{
+			Name: "WebFinger",
+			URL:  "/.well-known/webfinger?resource=acct:test@example.com",
+		},
+		{
+			Name: "GPG lookup",
+			URL:  "/pks/lookup?op=get&search=0x22A37A9A70E3965157E16007FE066B04B44DA0D3",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r, err := http.NewRequest("GET", tc.URL, nil)
+			assert.NoError(t, err)
+			app.Router.ServeHTTP(w, r)
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Contains(t, w.Header().Get("Cache-Control"), "max-age=")
+
+			etag := w.Header().Get("ETag")
+			assert.NotEmpty(t, etag)
+
+			w = httptest.NewRecorder()
+			r, err = http.NewRequest("GET", tc.URL, nil)
+			assert.NoError(t, err)
+			r.Header.Set("If-None-Match", etag)
+			app.Router.ServeHTTP(w, r)
+			assert.Equal(t, http.StatusNotModified, w.Code)
+			assert.Empty(t, w.Body.String())
+
+			w = httptest.NewRecorder()
+			r, err = http.NewRequest("GET", tc.URL, nil)
+			assert.NoError(t, err)
+			r.Header.Set("If-None-Match", `"stale"`)
+			app.Router.ServeHTTP(w, r)
+			assert.Equal(t, http.StatusOK, w.Code)
+		})
+	}
+}
+
+func TestHostMeta(t *testing.T) {
+	template := "http://example.com/.well-known/webfinger?resource={uri}"
+
+	t.Run("XRD", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r, err := http.NewRequest("GET", "/.well-known/host-meta", nil)
+		assert.NoError(t, err)
+		r.Host = "example.com"
+		app.Router.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, handler.ContentTypeXRD, w.Header().Get("Content-Type"))
+		assert.Contains(t, w.Body.String(), fmt.Sprintf(`<Link rel="lrdd" type="application/jrd+json" template="%s">`, template))
+
+		// The links come from the request, so shared caches must not keep them.
+		assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+		assert.Contains(t, w.Header().Get("Vary"), "X-Forwarded-Proto")
+		assert.Empty(t, w.Header().Get("ETag"))
+	})
+
+	t.Run("Public URL", func(t *testing.T) {
+		publicURL := config.Current.Server.PublicURL
+		defer func() {
+			config.Current.Server.PublicURL = publicURL
+		}()
+		config.Current.Server.PublicURL = "https://id.example.com"
+
+		w := httptest.NewRecorder()
+		r, err := http.NewRequest("GET", "/.well-known/host-meta", nil)
+		assert.NoError(t, err)
+		r.Host = "evil.example.net"
+		app.Router.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `template="https://id.example.com/.well-known/webfinger?resource={uri}"`)
+		assert.Contains(t, w.Header().Get("Cache-Control"), "public")
+		assert.NotEmpty(t, w.Header().Get("ETag"))
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r, err := http.NewRequest("GET", "/.well-known/host-meta.json", nil)
+		assert.NoError(t, err)
+		r.Host = "example.com"
+		app.Router.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, handler.ContentTypeJSON, w.Header().Get("Content-Type"))
+
+		responseBody := handler.WebFingerResponse{}
+		err = json.NewDecoder(w.Body).Decode(&responseBody)
+		assert.NoError(t, err)
+		assert.Equal(t, handler.LRDDRel, responseBody.Links[0].Rel)
+		assert.Equal(t, template, responseBody.Links[0].Template)
+	})
+
+	t.Run("WebFinger XRD", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r, err := http.NewRequest("GET", "/.well-known/webfinger?resource=acct:test@example.com", nil)
+		assert.NoError(t, err)
+		r.Header.Set("Accept", handler.ContentTypeXRD)
+		app.Router.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, handler.ContentTypeXRD, w.Header().Get("Content-Type"))
+		assert.Contains(t, w.Body.String(), "<Subject>acct:test@example.com</Subject>")
+	})
+}
+
+func TestWebFingerAdmin(t *testing.T) {
+	w := doJSONRequest(t, "POST", "/admin/webfinger/accounts", "", `{"subject": "acct:admin@example.com"}`)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = doJSONRequest(t, "POST", "/admin/webfinger/accounts", testAPIKey, `{"subject": "acct:admin@example1.com"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = doJSONRequest(t, "POST", "/admin/webfinger/accounts", testAPIKey, `{"subject": "acct:admin@example.com", "links": [{"href": "https://example.com"}]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = doJSONRequest(t, "POST", "/admin/webfinger/accounts", testAPIKey, `null`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = doJSONRequest(t, "POST", "/admin/webfinger/accounts", testAPIKey, `{
+		"subject": "acct:admin@example.com",
+		"aliases": ["https://example.com/~admin"],
+		"links": [{"rel": "http://openid.net/specs/connect/1.0/issuer", "href": "https://contractors.example.com"}]
+	}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	account := models.WebFingerAccount{}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&account))
+	assert.NotZero(t, account.ID)
+	accountURL := fmt.Sprintf("/admin/webfinger/accounts/%d", account.ID)
+
+	w = doJSONRequest(t, "POST", "/admin/webfinger/accounts", testAPIKey, `{"subject": "acct:admin@example.com"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = doJSONRequest(t, "GET", "/admin/webfinger/accounts?domain=example.com", testAPIKey, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	accounts := []models.WebFingerAccount{}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&accounts))
+	assert.NotEmpty(t, accounts)
+
+	// LIKE wildcards in the domain only match themselves.
+	w = doJSONRequest(t, "GET", "/admin/webfinger/accounts?domain=exampl_.com", testAPIKey, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&accounts))
+	assert.Empty(t, accounts)
+
+	assert.Equal(t, http.StatusBadRequest, doJSONRequest(t, "POST", accountURL+"/aliases", testAPIKey, `null`).Code)
+	assert.Equal(t, http.StatusBadRequest, doJSONRequest(t, "POST", accountURL+"/links", testAPIKey, `null`).Code)
+
+	w = doJSONRequest(t, "POST", accountURL+"/aliases", testAPIKey, `{"uri": "device:admin-laptop"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	alias := models.WebFingerAlias{}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&alias))
+
+	w = doJSONRequest(t, "POST", accountURL+"/links", testAPIKey, `{"rel": "http://webfinger.net/rel/profile-page", "href": "https://example.com/~admin"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	link := models.WebFingerLink{}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&link))
+	assert.NotZero(t, link.ID)
+
+	w = doJSONRequest(t, "PUT", fmt.Sprintf("%s/links/%d", accountURL, link.ID), testAPIKey, `{"rel": "http://webfinger.net/rel/profile-page", "href": "https://example.com/people/admin", "type": "text/html"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = doJSONRequest(t, "GET", accountURL, testAPIKey, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&account))
+	assert.Equal(t, []string{"https://example.com/~admin", "device:admin-laptop"}, account.AliasURIs())
+	assert.Len(t, account.Links, 2)
+	assert.Equal(t, "https://example.com/people/admin", account.Links[1].Href)
+
+	w = doJSONRequest(t, "GET", "/.well-known/webfinger?resource=device:admin-laptop", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "https://contractors.example.com")
+
+	w = doJSONRequest(t, "DELETE", fmt.Sprintf("%s/aliases/%d", accountURL, alias.ID), testAPIKey, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = doJSONRequest(t, "DELETE", fmt.Sprintf("%s/links/%d", accountURL, link.ID), testAPIKey, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = doJSONRequest(t, "DELETE", fmt.Sprintf("%s/links/%d", accountURL, link.ID), testAPIKey, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = doJSONRequest(t, "PUT", accountURL, testAPIKey, `{"subject": "acct:admin@example.com", "properties": {"http://example.com/ns/role": "admin"}}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&account))
+	assert.Empty(t, account.Aliases)
+	assert.Empty(t, account.Links)
+	assert.Equal(t, "admin", *account.Properties["http://example.com/ns/role"])
+
+	w = doJSONRequest(t, "DELETE", accountURL, testAPIKey, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = doJSONRequest(t, "GET", accountURL, testAPIKey, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = doJSONRequest(t, "GET", "/.well-known/webfinger?resource=acct:admin@example.com", "", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
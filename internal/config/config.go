@@ -1,7 +1,13 @@
 package config
 
 import (
+	"fmt"
 	"log"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
 
 	"github.com/google/uuid"
 	"github.com/hibare/DomainHQ/internal/constants"
@@ -12,15 +18,132 @@ import (
 type ServerConfig struct {
 	ListenAddr string
 	ListenPort int
+	PublicURL  string
 }
 
 type APIConfig struct {
 	APIKeys []string
 }
 
-type WebFingerConfig struct {
+type HTTPConfig struct {
+	CORSAllowedOrigins []string
+	CacheMaxAge        time.Duration
+	// UploadRateLimit is the number of unauthenticated uploads a client may make per minute.
+	UploadRateLimit int
+	// TrustedProxies are the networks of the reverse proxies whose X-Forwarded-For and
+	// X-Real-IP headers are believed. Other clients are identified by their connection.
+	TrustedProxies []netip.Prefix
+}
+
+type WebFingerLink struct {
+	Rel  string
+	Href string
+}
+
+type WebFingerDomain struct {
+	Name     string
 	Resource string
-	Domain   string
+	Links    []WebFingerLink
+}
+
+type WebFingerResolverConfig struct {
+	Backend  string
+	Accounts []string
+	URL      string
+	Token    string
+	Timeout  time.Duration
+}
+
+type WebFingerConfig struct {
+	Domains  []WebFingerDomain
+	Schemes  []string
+	Resolver WebFingerResolverConfig
+}
+
+// LookupDomain returns the WebFinger settings for the given domain.
+func (c *WebFingerConfig) LookupDomain(name string) (*WebFingerDomain, bool) {
+	name = strings.ToLower(name)
+	for i := range c.Domains {
+		if c.Domains[i].Name == name {
+			return &c.Domains[i], true
+		}
+	}
+	return nil, false
+}
+
+// DomainNames returns the names of all configured domains.
+func (c *WebFingerConfig) DomainNames() []string {
+	names := []string{}
+	for _, d := range c.Domains {
+		names = append(names, d.Name)
+	}
+	return names
+}
+
+type GPGConfig struct {
+	RefuseDeletedKeys bool
+	// HideExpired leaves expired keys out of searches by email.
+	HideExpired           bool
+	StatusRefreshInterval time.Duration
+	// UIDPolicy decides what happens to uploaded user IDs whose email is not in one of the
+	// WebFinger domains: they are accepted or stripped from the key.
+	UIDPolicy string
+}
+
+// UpstreamConfig lists the keyservers asked when a key is not stored locally. HKP servers
+// are tried first, then VKS servers, each in the configured order.
+type UpstreamConfig struct {
+	HKPServers []string
+	VKSServers []string
+	Timeout    time.Duration
+	CacheTTL   time.Duration
+	// MissTTL is how long a search no keyserver had a key for is remembered.
+	MissTTL time.Duration
+}
+
+// Enabled reports whether any upstream keyserver is configured.
+func (c *UpstreamConfig) Enabled() bool {
+	return len(c.HKPServers) > 0 || len(c.VKSServers) > 0
+}
+
+// ReplicationPeer is another DomainHQ instance whose key changes are pulled. Token is one of
+// the peer's replication tokens.
+type ReplicationPeer struct {
+	Name  string
+	URL   string
+	Token string
+}
+
+type ReplicationConfig struct {
+	Peers    []ReplicationPeer
+	Interval time.Duration
+	Timeout  time.Duration
+	// Tokens let peers read the change feed of this instance and nothing else.
+	Tokens []string
+	// Settle holds changes back from the feed until they are this old, so a transaction
+	// that commits after a later stamped one is not skipped by peers already past it.
+	Settle time.Duration
+}
+
+// Enabled reports whether any replication peer is configured.
+func (c *ReplicationConfig) Enabled() bool {
+	return len(c.Peers) > 0
+}
+
+type VerificationConfig struct {
+	Enabled  bool
+	Secret   string
+	TokenTTL time.Duration
+}
+
+type MailerConfig struct {
+	Backend  string
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	Timeout  time.Duration
 }
 
 type DBConfig struct {
@@ -37,19 +160,152 @@ type LoggerConfig struct {
 }
 
 type Config struct {
-	Server    ServerConfig
-	WebFinger WebFingerConfig
-	DB        DBConfig
-	API       APIConfig
-	Logger    LoggerConfig
+	Server       ServerConfig
+	WebFinger    WebFingerConfig
+	GPG          GPGConfig
+	Upstream     UpstreamConfig
+	Replication  ReplicationConfig
+	Verification VerificationConfig
+	Mailer       MailerConfig
+	DB           DBConfig
+	API          APIConfig
+	HTTP         HTTPConfig
+	Logger       LoggerConfig
 }
 
 var Current *Config
 
+// domainEnvKey converts a domain name into an env var suffix, e.g. example.com -> EXAMPLE_COM.
+func domainEnvKey(domain string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, domain)
+}
+
+// parseWebFingerLinks parses a comma separated list of "<rel> <href>" pairs.
+func parseWebFingerLinks(value string) ([]WebFingerLink, error) {
+	links := []WebFingerLink{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		fields := strings.Fields(item)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid link %q, expected '<rel> <href>'", item)
+		}
+		links = append(links, WebFingerLink{Rel: fields[0], Href: fields[1]})
+	}
+	return links, nil
+}
+
+func loadWebFingerConfig() WebFingerConfig {
+	defaultResource := env.MustString("DOMAIN_HQ_WEB_FINGER_RESOURCE", constants.DefaultWebFingerResource)
+	names := env.MustStringSlice("DOMAIN_HQ_WEB_FINGER_DOMAINS", []string{
+		env.MustString("DOMAIN_HQ_WEB_FINGER_DOMAIN", constants.DefaultWebFingerDomain),
+	})
+
+	cfg := WebFingerConfig{
+		Resolver: WebFingerResolverConfig{
+			Backend:  strings.ToLower(env.MustString("DOMAIN_HQ_WEB_FINGER_RESOLVER", constants.DefaultResolverBackend)),
+			Accounts: env.MustStringSlice("DOMAIN_HQ_WEB_FINGER_RESOLVER_ACCOUNTS", []string{}),
+			URL:      env.MustString("DOMAIN_HQ_WEB_FINGER_RESOLVER_URL", ""),
+			Token:    env.MustString("DOMAIN_HQ_WEB_FINGER_RESOLVER_TOKEN", ""),
+			Timeout:  env.MustDuration("DOMAIN_HQ_WEB_FINGER_RESOLVER_TIMEOUT", constants.DefaultResolverTimeout),
+		},
+	}
+	for _, scheme := range env.MustStringSlice("DOMAIN_HQ_WEB_FINGER_SCHEMES", strings.Split(constants.DefaultWebFingerSchemes, ",")) {
+		if scheme = strings.ToLower(strings.TrimSpace(scheme)); scheme != "" {
+			cfg.Schemes = append(cfg.Schemes, scheme)
+		}
+	}
+
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		key := domainEnvKey(name)
+		links, err := parseWebFingerLinks(env.MustString("DOMAIN_HQ_WEB_FINGER_LINKS_"+key, ""))
+		if err != nil {
+			log.Fatalf("Error invalid WebFinger links for %s: %v", name, err)
+		}
+
+		cfg.Domains = append(cfg.Domains, WebFingerDomain{
+			Name:     name,
+			Resource: env.MustString("DOMAIN_HQ_WEB_FINGER_RESOURCE_"+key, defaultResource),
+			Links:    links,
+		})
+	}
+	return cfg
+}
+
+func loadReplicationConfig() ReplicationConfig {
+	cfg := ReplicationConfig{
+		Interval: env.MustDuration("DOMAIN_HQ_REPLICATION_INTERVAL", constants.DefaultReplicationInterval),
+		Timeout:  env.MustDuration("DOMAIN_HQ_REPLICATION_TIMEOUT", constants.DefaultReplicationTimeout),
+		Tokens:   nonEmpty(env.MustStringSlice("DOMAIN_HQ_REPLICATION_TOKENS", []string{})),
+		Settle:   env.MustDuration("DOMAIN_HQ_REPLICATION_SETTLE", constants.DefaultReplicationSettle),
+	}
+
+	for _, name := range nonEmpty(env.MustStringSlice("DOMAIN_HQ_REPLICATION_PEERS", []string{})) {
+		name = strings.ToLower(name)
+		key := domainEnvKey(name)
+		peer := ReplicationPeer{
+			Name:  name,
+			URL:   strings.TrimSuffix(env.MustString("DOMAIN_HQ_REPLICATION_PEER_URL_"+key, ""), "/"),
+			Token: env.MustString("DOMAIN_HQ_REPLICATION_PEER_TOKEN_"+key, ""),
+		}
+		if peer.URL == "" || peer.Token == "" {
+			log.Fatalf("Error missing replication URL or token for peer %s", name)
+		}
+		cfg.Peers = append(cfg.Peers, peer)
+	}
+	return cfg
+}
+
+// parseTrustedProxies parses a list of networks, each given in CIDR notation or as a single
+// address.
+func parseTrustedProxies(values []string) ([]netip.Prefix, error) {
+	prefixes := []netip.Prefix{}
+	for _, value := range nonEmpty(values) {
+		if addr, err := netip.ParseAddr(value); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", value)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// nonEmpty trims the values and drops the empty ones.
+func nonEmpty(values []string) []string {
+	result := []string{}
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
 func LoadConfig() {
 
 	env.Load()
 
+	trustedProxies, err := parseTrustedProxies(env.MustStringSlice("DOMAIN_HQ_TRUSTED_PROXIES", []string{}))
+	if err != nil {
+		log.Fatalf("Error %v", err)
+	}
+
 	token := []string{
 		uuid.New().String(),
 	}
@@ -58,10 +314,36 @@ func LoadConfig() {
 		Server: ServerConfig{
 			ListenAddr: env.MustString("DOMAIN_HQ_LISTEN_ADDR", constants.DefaultAPIListenAddr),
 			ListenPort: env.MustInt("DOMAIN_HQ_LISTEN_PORT", constants.DefaultAPIListenPort),
+			PublicURL:  strings.TrimSuffix(env.MustString("DOMAIN_HQ_PUBLIC_URL", ""), "/"),
 		},
-		WebFinger: WebFingerConfig{
-			Resource: env.MustString("DOMAIN_HQ_WEB_FINGER_RESOURCE", constants.DefaultWebFingerResource),
-			Domain:   env.MustString("DOMAIN_HQ_WEB_FINGER_DOMAIN", constants.DefaultWebFingerDomain),
+		WebFinger: loadWebFingerConfig(),
+		GPG: GPGConfig{
+			RefuseDeletedKeys:     env.MustBool("DOMAIN_HQ_GPG_REFUSE_DELETED_KEYS", true),
+			HideExpired:           env.MustBool("DOMAIN_HQ_GPG_HIDE_EXPIRED", false),
+			StatusRefreshInterval: env.MustDuration("DOMAIN_HQ_GPG_STATUS_REFRESH_INTERVAL", constants.DefaultStatusRefreshInterval),
+			UIDPolicy:             strings.ToLower(env.MustString("DOMAIN_HQ_GPG_UID_POLICY", constants.DefaultUIDPolicy)),
+		},
+		Upstream: UpstreamConfig{
+			HKPServers: nonEmpty(env.MustStringSlice("DOMAIN_HQ_UPSTREAM_HKP_SERVERS", []string{})),
+			VKSServers: nonEmpty(env.MustStringSlice("DOMAIN_HQ_UPSTREAM_VKS_SERVERS", []string{})),
+			Timeout:    env.MustDuration("DOMAIN_HQ_UPSTREAM_TIMEOUT", constants.DefaultUpstreamTimeout),
+			CacheTTL:   env.MustDuration("DOMAIN_HQ_UPSTREAM_CACHE_TTL", constants.DefaultUpstreamCacheTTL),
+			MissTTL:    env.MustDuration("DOMAIN_HQ_UPSTREAM_MISS_TTL", constants.DefaultUpstreamMissTTL),
+		},
+		Replication: loadReplicationConfig(),
+		Verification: VerificationConfig{
+			Enabled:  env.MustBool("DOMAIN_HQ_VERIFICATION_ENABLED", false),
+			Secret:   env.MustString("DOMAIN_HQ_VERIFICATION_SECRET", ""),
+			TokenTTL: env.MustDuration("DOMAIN_HQ_VERIFICATION_TOKEN_TTL", constants.DefaultTokenTTL),
+		},
+		Mailer: MailerConfig{
+			Backend:  strings.ToLower(env.MustString("DOMAIN_HQ_MAILER", constants.DefaultMailerBackend)),
+			Host:     env.MustString("DOMAIN_HQ_SMTP_HOST", ""),
+			Port:     env.MustInt("DOMAIN_HQ_SMTP_PORT", constants.DefaultSMTPPort),
+			Username: env.MustString("DOMAIN_HQ_SMTP_USERNAME", ""),
+			Password: env.MustString("DOMAIN_HQ_SMTP_PASSWORD", ""),
+			From:     env.MustString("DOMAIN_HQ_SMTP_FROM", ""),
+			Timeout:  env.MustDuration("DOMAIN_HQ_SMTP_TIMEOUT", constants.DefaultSMTPTimeout),
 		},
 		DB: DBConfig{
 			Username: env.MustString("DOMAIN_HQ_DB_USERNAME", ""),
@@ -73,12 +355,78 @@ func LoadConfig() {
 		API: APIConfig{
 			APIKeys: env.MustStringSlice("DOMAIN_HQ_API_KEYS", token),
 		},
+		HTTP: HTTPConfig{
+			CORSAllowedOrigins: env.MustStringSlice("DOMAIN_HQ_CORS_ALLOWED_ORIGINS", []string{constants.DefaultCORSAllowedOrigin}),
+			CacheMaxAge:        env.MustDuration("DOMAIN_HQ_CACHE_MAX_AGE", constants.DefaultCacheMaxAge),
+			UploadRateLimit:    env.MustInt("DOMAIN_HQ_UPLOAD_RATE_LIMIT", constants.DefaultUploadRateLimit),
+			TrustedProxies:     trustedProxies,
+		},
 		Logger: LoggerConfig{
 			Level: env.MustString("DOMAIN_HQ_LOG_LEVEL", commonLogger.DefaultLoggerLevel),
 			Mode:  env.MustString("DOMAIN_HQ_LOG_MODE", commonLogger.DefaultLoggerMode),
 		},
 	}
 
+	if len(Current.WebFinger.Domains) == 0 {
+		log.Fatal("Error missing WebFinger domains")
+	}
+
+	resolverBackends := []string{constants.ResolverBackendStatic, constants.ResolverBackendDatabase, constants.ResolverBackendHTTP}
+	if !slices.Contains(resolverBackends, Current.WebFinger.Resolver.Backend) {
+		log.Fatal("Error invalid WebFinger resolver backend")
+	}
+
+	if Current.WebFinger.Resolver.Backend == constants.ResolverBackendHTTP && Current.WebFinger.Resolver.URL == "" {
+		log.Fatal("Error missing WebFinger resolver URL")
+	}
+
+	if !slices.Contains([]string{constants.MailerBackendSMTP, constants.MailerBackendMemory}, Current.Mailer.Backend) {
+		log.Fatal("Error invalid mailer backend")
+	}
+
+	if Current.GPG.StatusRefreshInterval <= 0 {
+		log.Fatal("Error invalid GPG status refresh interval")
+	}
+
+	if !slices.Contains([]string{constants.UIDPolicyAccept, constants.UIDPolicyStrip}, Current.GPG.UIDPolicy) {
+		log.Fatal("Error invalid GPG UID policy")
+	}
+
+	if Current.HTTP.UploadRateLimit <= 0 {
+		log.Fatal("Error invalid upload rate limit")
+	}
+
+	if Current.Upstream.Enabled() && (Current.Upstream.Timeout <= 0 || Current.Upstream.CacheTTL <= 0 || Current.Upstream.MissTTL <= 0) {
+		log.Fatal("Error invalid upstream timeout or cache TTL")
+	}
+
+	if Current.Replication.Enabled() && (Current.Replication.Interval <= 0 || Current.Replication.Timeout <= 0) {
+		log.Fatal("Error invalid replication interval or timeout")
+	}
+
+	if Current.Replication.Settle < 0 {
+		log.Fatal("Error invalid replication settle time")
+	}
+
+	if Current.Verification.Enabled {
+		if Current.Verification.Secret == "" {
+			log.Fatal("Error missing verification secret")
+		}
+
+		// Verification links are mailed out, so they must not be built from the request Host.
+		if Current.Server.PublicURL == "" {
+			log.Fatal("Error missing public URL, required for verification links")
+		}
+
+		if Current.Mailer.Backend == constants.MailerBackendSMTP && (Current.Mailer.Host == "" || Current.Mailer.From == "") {
+			log.Fatal("Error missing SMTP host or sender")
+		}
+
+		if Current.Mailer.Backend == constants.MailerBackendSMTP && Current.Mailer.Timeout <= 0 {
+			log.Fatal("Error invalid SMTP timeout")
+		}
+	}
+
 	if Current.DB.Username == "" {
 		log.Fatal("Error missing DB username")
 	}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"net/netip"
 	"os"
 	"strconv"
 	"testing"
@@ -40,6 +41,8 @@ func unsetEnv() {
 	os.Unsetenv("DOMAIN_HQ_LISTEN_PORT")
 	os.Unsetenv("DOMAIN_HQ_WEB_FINGER_DOMAIN")
 	os.Unsetenv("DOMAIN_HQ_WEB_FINGER_RESOURCE")
+	os.Unsetenv("DOMAIN_HQ_WEB_FINGER_DOMAINS")
+	os.Unsetenv("DOMAIN_HQ_WEB_FINGER_SCHEMES")
 	os.Unsetenv("DOMAIN_HQ_DB_USERNAME")
 	os.Unsetenv("DOMAIN_HQ_DB_PASSWORD")
 	os.Unsetenv("DOMAIN_HQ_DB_HOST")
@@ -57,8 +60,9 @@ func TestEnvLoadedConfig(t *testing.T) {
 
 	assert.Equal(t, testAPIListenAddr, Current.Server.ListenAddr)
 	assert.Equal(t, testAPIListenPort, Current.Server.ListenPort)
-	assert.Equal(t, testWebFingerDomain, Current.WebFinger.Domain)
-	assert.Equal(t, testWebFingerResource, Current.WebFinger.Resource)
+	assert.Len(t, Current.WebFinger.Domains, 1)
+	assert.Equal(t, testWebFingerDomain, Current.WebFinger.Domains[0].Name)
+	assert.Equal(t, testWebFingerResource, Current.WebFinger.Domains[0].Resource)
 	assert.Equal(t, testDBUsername, Current.DB.Username)
 	assert.Equal(t, testDBPassword, Current.DB.Password)
 	assert.Equal(t, []string{testAPIKeys}, Current.API.APIKeys)
@@ -76,11 +80,116 @@ func TestDefaultConfig(t *testing.T) {
 
 	assert.Equal(t, constants.DefaultAPIListenAddr, Current.Server.ListenAddr)
 	assert.Equal(t, constants.DefaultAPIListenPort, Current.Server.ListenPort)
-	assert.Equal(t, constants.DefaultWebFingerDomain, Current.WebFinger.Domain)
-	assert.Equal(t, constants.DefaultWebFingerResource, Current.WebFinger.Resource)
+	assert.Len(t, Current.WebFinger.Domains, 1)
+	assert.Equal(t, constants.DefaultWebFingerDomain, Current.WebFinger.Domains[0].Name)
+	assert.Equal(t, constants.DefaultWebFingerResource, Current.WebFinger.Domains[0].Resource)
+	assert.Equal(t, []string{"acct", "mailto", "https", "device"}, Current.WebFinger.Schemes)
+	assert.Equal(t, constants.DefaultResolverBackend, Current.WebFinger.Resolver.Backend)
+	assert.Equal(t, constants.DefaultResolverTimeout, Current.WebFinger.Resolver.Timeout)
+	assert.Equal(t, []string{constants.DefaultCORSAllowedOrigin}, Current.HTTP.CORSAllowedOrigins)
+	assert.Equal(t, constants.DefaultCacheMaxAge, Current.HTTP.CacheMaxAge)
+	assert.Equal(t, constants.DefaultUploadRateLimit, Current.HTTP.UploadRateLimit)
+	assert.Empty(t, Current.HTTP.TrustedProxies)
+	assert.True(t, Current.GPG.RefuseDeletedKeys)
+	assert.False(t, Current.GPG.HideExpired)
+	assert.Equal(t, constants.DefaultStatusRefreshInterval, Current.GPG.StatusRefreshInterval)
+	assert.Equal(t, constants.UIDPolicyAccept, Current.GPG.UIDPolicy)
+	assert.False(t, Current.Upstream.Enabled())
+	assert.Equal(t, constants.DefaultUpstreamCacheTTL, Current.Upstream.CacheTTL)
+	assert.Equal(t, constants.DefaultUpstreamMissTTL, Current.Upstream.MissTTL)
+	assert.False(t, Current.Replication.Enabled())
+	assert.Equal(t, constants.DefaultReplicationInterval, Current.Replication.Interval)
+	assert.Empty(t, Current.Replication.Tokens)
+	assert.False(t, Current.Verification.Enabled)
+	assert.Equal(t, constants.DefaultTokenTTL, Current.Verification.TokenTTL)
+	assert.Equal(t, constants.DefaultMailerBackend, Current.Mailer.Backend)
+	assert.Equal(t, constants.DefaultSMTPPort, Current.Mailer.Port)
+	assert.Equal(t, constants.DefaultSMTPTimeout, Current.Mailer.Timeout)
 	assert.Equal(t, testDBUsername, Current.DB.Username)
 	assert.Equal(t, testDBPassword, Current.DB.Password)
 	assert.NotEmpty(t, Current.API.APIKeys)
 	assert.Equal(t, commonLogger.DefaultLoggerLevel, Current.Logger.Level)
 	assert.Equal(t, commonLogger.DefaultLoggerMode, Current.Logger.Mode)
 }
+
+func TestMultiDomainConfig(t *testing.T) {
+	unsetEnv()
+	os.Setenv("DOMAIN_HQ_DB_USERNAME", testDBUsername)
+	os.Setenv("DOMAIN_HQ_DB_PASSWORD", testDBPassword)
+	os.Setenv("DOMAIN_HQ_WEB_FINGER_DOMAINS", "example1.com,Example2.com")
+	os.Setenv("DOMAIN_HQ_WEB_FINGER_RESOURCE", testWebFingerResource)
+	os.Setenv("DOMAIN_HQ_WEB_FINGER_SCHEMES", "acct, MAILTO")
+	os.Setenv("DOMAIN_HQ_WEB_FINGER_RESOURCE_EXAMPLE2_COM", "https://id.example2.com")
+	os.Setenv("DOMAIN_HQ_WEB_FINGER_LINKS_EXAMPLE2_COM", "http://webfinger.net/rel/profile-page https://example2.com/profile")
+	defer func() {
+		os.Unsetenv("DOMAIN_HQ_WEB_FINGER_RESOURCE_EXAMPLE2_COM")
+		os.Unsetenv("DOMAIN_HQ_WEB_FINGER_LINKS_EXAMPLE2_COM")
+		unsetEnv()
+	}()
+
+	LoadConfig()
+
+	assert.Equal(t, []string{"example1.com", "example2.com"}, Current.WebFinger.DomainNames())
+
+	domain, ok := Current.WebFinger.LookupDomain("example1.com")
+	assert.True(t, ok)
+	assert.Equal(t, testWebFingerResource, domain.Resource)
+	assert.Empty(t, domain.Links)
+
+	domain, ok = Current.WebFinger.LookupDomain("EXAMPLE2.COM")
+	assert.True(t, ok)
+	assert.Equal(t, "https://id.example2.com", domain.Resource)
+	assert.Equal(t, []WebFingerLink{{Rel: "http://webfinger.net/rel/profile-page", Href: "https://example2.com/profile"}}, domain.Links)
+
+	_, ok = Current.WebFinger.LookupDomain("example3.com")
+	assert.False(t, ok)
+
+	assert.Equal(t, []string{"acct", "mailto"}, Current.WebFinger.Schemes)
+}
+
+func TestReplicationConfig(t *testing.T) {
+	unsetEnv()
+	os.Setenv("DOMAIN_HQ_DB_USERNAME", testDBUsername)
+	os.Setenv("DOMAIN_HQ_DB_PASSWORD", testDBPassword)
+	os.Setenv("DOMAIN_HQ_REPLICATION_PEERS", "EU-West, ")
+	os.Setenv("DOMAIN_HQ_REPLICATION_PEER_URL_EU_WEST", "https://keys-eu.example.com/")
+	os.Setenv("DOMAIN_HQ_REPLICATION_PEER_TOKEN_EU_WEST", "peer-token")
+	os.Setenv("DOMAIN_HQ_REPLICATION_TOKENS", "feed-token-1, feed-token-2")
+	defer func() {
+		os.Unsetenv("DOMAIN_HQ_REPLICATION_TOKENS")
+		os.Unsetenv("DOMAIN_HQ_REPLICATION_PEERS")
+		os.Unsetenv("DOMAIN_HQ_REPLICATION_PEER_URL_EU_WEST")
+		os.Unsetenv("DOMAIN_HQ_REPLICATION_PEER_TOKEN_EU_WEST")
+		unsetEnv()
+	}()
+
+	LoadConfig()
+
+	assert.True(t, Current.Replication.Enabled())
+	assert.Equal(t, []ReplicationPeer{{Name: "eu-west", URL: "https://keys-eu.example.com", Token: "peer-token"}}, Current.Replication.Peers)
+	assert.Equal(t, constants.DefaultReplicationTimeout, Current.Replication.Timeout)
+	assert.Equal(t, []string{"feed-token-1", "feed-token-2"}, Current.Replication.Tokens)
+	assert.Equal(t, constants.DefaultReplicationSettle, Current.Replication.Settle)
+}
+
+func TestTrustedProxiesConfig(t *testing.T) {
+	unsetEnv()
+	os.Setenv("DOMAIN_HQ_DB_USERNAME", testDBUsername)
+	os.Setenv("DOMAIN_HQ_DB_PASSWORD", testDBPassword)
+	os.Setenv("DOMAIN_HQ_TRUSTED_PROXIES", "10.1.2.3/8, 192.0.2.10, ::1")
+	defer func() {
+		os.Unsetenv("DOMAIN_HQ_TRUSTED_PROXIES")
+		unsetEnv()
+	}()
+
+	LoadConfig()
+
+	assert.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.0.2.10/32"),
+		netip.MustParsePrefix("::1/128"),
+	}, Current.HTTP.TrustedProxies)
+
+	_, err := parseTrustedProxies([]string{"proxy.example.com"})
+	assert.Error(t, err)
+}
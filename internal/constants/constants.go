@@ -1,13 +1,52 @@
 package constants
 
+import "time"
+
 const (
-	DefaultAPIListenAddr     = "0.0.0.0"
-	DefaultAPIListenPort     = 5000
-	DefaultWebFingerDomain   = "example.com"
-	DefaultWebFingerResource = "https://auth.example.com"
-	DefaultDBPort            = 5432
-	DefaultDBName            = "domain_hq"
-	DefaultDBHost            = "localhost"
+	DefaultAPIListenAddr         = "0.0.0.0"
+	DefaultAPIListenPort         = 5000
+	DefaultWebFingerDomain       = "example.com"
+	DefaultWebFingerResource     = "https://auth.example.com"
+	DefaultWebFingerSchemes      = "acct,mailto,https,device"
+	DefaultResolverTimeout       = 10 * time.Second
+	DefaultCORSAllowedOrigin     = "*"
+	DefaultCacheMaxAge           = time.Hour
+	DefaultUploadRateLimit       = 10
+	DefaultTokenTTL              = 24 * time.Hour
+	DefaultStatusRefreshInterval = time.Hour
+	DefaultUpstreamTimeout       = 10 * time.Second
+	DefaultUpstreamCacheTTL      = 24 * time.Hour
+	DefaultUpstreamMissTTL       = 5 * time.Minute
+	DefaultReplicationInterval   = time.Minute
+	DefaultReplicationTimeout    = 30 * time.Second
+	DefaultReplicationSettle     = 30 * time.Second
+	DefaultSMTPPort              = 587
+	DefaultSMTPTimeout           = 30 * time.Second
+	DefaultDBPort                = 5432
+	DefaultDBName                = "domain_hq"
+	DefaultDBHost                = "localhost"
 
 	GPGFingerprintPrefix = "0x"
 )
+
+const (
+	ResolverBackendStatic   = "static"
+	ResolverBackendDatabase = "database"
+	ResolverBackendHTTP     = "http"
+
+	DefaultResolverBackend = ResolverBackendDatabase
+)
+
+const (
+	UIDPolicyAccept = "accept"
+	UIDPolicyStrip  = "strip"
+
+	DefaultUIDPolicy = UIDPolicyAccept
+)
+
+const (
+	MailerBackendSMTP   = "smtp"
+	MailerBackendMemory = "memory"
+
+	DefaultMailerBackend = MailerBackendSMTP
+)
@@ -0,0 +1,138 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hibare/DomainHQ/internal/config"
+	"github.com/hibare/DomainHQ/internal/constants"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	m, err := New(config.MailerConfig{Backend: constants.MailerBackendSMTP, Host: "localhost", Port: 25})
+	assert.NoError(t, err)
+	assert.IsType(t, &SMTPMailer{}, m)
+
+	m, err = New(config.MailerConfig{Backend: constants.MailerBackendMemory})
+	assert.NoError(t, err)
+	assert.IsType(t, &MemoryMailer{}, m)
+
+	_, err = New(config.MailerConfig{Backend: "carrier-pigeon"})
+	assert.Error(t, err)
+}
+
+func TestMemoryMailer(t *testing.T) {
+	m := NewMemoryMailer()
+	msg := Message{To: "example@example.com", Subject: "Hello", Body: "Hello there"}
+
+	assert.NoError(t, m.Send(context.Background(), msg))
+	assert.Equal(t, []Message{msg}, m.Messages())
+
+	m.Reset()
+	assert.Empty(t, m.Messages())
+}
+
+// smtpListener starts a TCP listener on a free local port and returns its host and port.
+func smtpListener(t *testing.T) (net.Listener, string, int) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	host, port, err := net.SplitHostPort(l.Addr().String())
+	assert.NoError(t, err)
+	p, err := strconv.Atoi(port)
+	assert.NoError(t, err)
+	return l, host, p
+}
+
+func TestSMTPMailer(t *testing.T) {
+	l, host, port := smtpListener(t)
+
+	data := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		conn.Write([]byte("220 localhost ESMTP\r\n"))
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				conn.Write([]byte("250 localhost\r\n"))
+			case cmd == "DATA":
+				conn.Write([]byte("354 go ahead\r\n"))
+				body := &strings.Builder{}
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					body.WriteString(line)
+				}
+				data <- body.String()
+				conn.Write([]byte("250 queued\r\n"))
+			case cmd == "QUIT":
+				conn.Write([]byte("221 bye\r\n"))
+				return
+			default:
+				conn.Write([]byte("250 ok\r\n"))
+			}
+		}
+	}()
+
+	m := NewSMTPMailer(host, port, "", "", "keys@example.com", time.Second)
+	msg := Message{To: "example@example.com", Subject: "Hello", Body: "Hello there"}
+	assert.NoError(t, m.Send(context.Background(), msg))
+
+	body := <-data
+	assert.Contains(t, body, "To: example@example.com\r\n")
+	assert.Contains(t, body, "Subject: Hello\r\n")
+	assert.Contains(t, body, "Hello there")
+}
+
+func TestSMTPMailerTimeout(t *testing.T) {
+	// The server accepts connections but never greets the client.
+	l, host, port := smtpListener(t)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+	msg := Message{To: "example@example.com", Subject: "Hello", Body: "Hello there"}
+
+	t.Run("Timeout", func(t *testing.T) {
+		m := NewSMTPMailer(host, port, "", "", "keys@example.com", 100*time.Millisecond)
+		start := time.Now()
+		err := m.Send(context.Background(), msg)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Less(t, time.Since(start), 5*time.Second)
+	})
+
+	t.Run("Context", func(t *testing.T) {
+		m := NewSMTPMailer(host, port, "", "", "keys@example.com", time.Minute)
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(100*time.Millisecond, cancel)
+		start := time.Now()
+		err := m.Send(ctx, msg)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Less(t, time.Since(start), 5*time.Second)
+	})
+}
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"database/sql/driver"
+	"encoding/json"
 	"fmt"
 
 	"github.com/hibare/DomainHQ/internal/config"
@@ -8,16 +10,55 @@ import (
 	"gorm.io/gorm"
 )
 
+// JSONMap is a string keyed map persisted as a JSON column.
+type JSONMap[V any] map[string]V
+
+func (JSONMap[V]) GormDataType() string {
+	return "jsonb"
+}
+
+func (m JSONMap[V]) Value() (driver.Value, error) {
+	if m == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (m *JSONMap[V]) Scan(value any) error {
+	switch v := value.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, m)
+	case string:
+		return json.Unmarshal([]byte(v), m)
+	default:
+		return fmt.Errorf("unsupported JSON column type %T", value)
+	}
+}
+
 func getDBUrl() string {
 	return fmt.Sprintf("postgres://%s:%s@%s:%d/%s", config.Current.DB.Username, config.Current.DB.Password, config.Current.DB.Host, config.Current.DB.Port, config.Current.DB.Name)
 }
 
 func InitDB() (*gorm.DB, error) {
-	db, err := gorm.Open(postgres.Open(getDBUrl()), &gorm.Config{})
+	db, err := gorm.Open(postgres.Open(getDBUrl()), &gorm.Config{TranslateError: true})
 	if err != nil {
 		return db, err
 	}
 
-	db.AutoMigrate(&GPGPubKeyStore{}, &GPGUsers{})
-	return db, nil
+	return db, Migrate(db)
+}
+
+// Migrate applies the pending data migrations and brings the schema up to date.
+func Migrate(db *gorm.DB) error {
+	if err := runMigrations(db); err != nil {
+		return err
+	}
+	return db.AutoMigrate(&GPGPubKeyStore{}, &GPGUsers{}, &GPGSubkey{}, &GPGVerifiedEmail{}, &GPGUpstreamKey{}, &ReplicationState{}, &WebFingerAccount{}, &WebFingerAlias{}, &WebFingerLink{})
 }
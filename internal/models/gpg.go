@@ -3,107 +3,901 @@ package models
 import (
 	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/hibare/DomainHQ/internal/config"
 	"github.com/hibare/DomainHQ/internal/constants"
 	"gorm.io/gorm"
 )
 
+// GPGUsers is a user ID of a stored key, as certified by the key's latest self-signature.
 type GPGUsers struct {
-	ID      string `gorm:"primaryKey;autoIncrement" json:"-"`
+	ID      uint   `gorm:"primaryKey" json:"-"`
+	KeyID   string `gorm:"index" json:"-"`
 	Name    string `json:"name"`
-	Email   string `json:"email"`
+	Email   string `gorm:"index" json:"email"`
+	WKDHash string `gorm:"index" json:"-"`
 	Comment string `json:"comment"`
+	Primary bool   `json:"primary"`
+	Revoked bool   `json:"revoked"`
+	// SignedAt is when the self-signature was made, ExpiresAt when it makes the key expire.
+	SignedAt  time.Time  `json:"signed_at"`
+	ExpiresAt *time.Time `json:"expires_at"`
 }
 
 func (GPGUsers) TableName() string {
 	return "gpg_users"
 }
 
+// GPGVerifiedEmail records that the owner of an address confirmed publishing it on a key.
+type GPGVerifiedEmail struct {
+	ID         uint      `gorm:"primaryKey" json:"-"`
+	KeyID      string    `gorm:"uniqueIndex:idx_gpg_verified_email" json:"key_id"`
+	Email      string    `gorm:"uniqueIndex:idx_gpg_verified_email" json:"email"`
+	VerifiedAt time.Time `json:"verified_at"`
+}
+
+func (GPGVerifiedEmail) TableName() string {
+	return "gpg_verified_emails"
+}
+
+// GPGSubkey is a signing, encryption or authentication subkey of a stored key.
+type GPGSubkey struct {
+	ID          uint       `gorm:"primaryKey" json:"-"`
+	ParentKeyID string     `gorm:"index" json:"-"`
+	KeyID       string     `gorm:"index" json:"key_id"`
+	KeyIDShort  string     `gorm:"index" json:"key_id_short"`
+	Fingerprint string     `gorm:"index" json:"fingerprint"`
+	Algorithm   string     `json:"algorithm"`
+	BitLength   int        `json:"bit_length"`
+	Curve       string     `json:"curve,omitempty"`
+	CurveOID    string     `gorm:"column:curve_oid" json:"curve_oid,omitempty"`
+	Usage       string     `json:"usage"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	Revoked     bool       `json:"revoked"`
+	Expired     bool       `json:"expired"`
+}
+
+func (GPGSubkey) TableName() string {
+	return "gpg_subkeys"
+}
+
 type GPGPubKeyStore struct {
 	KeyID       string     `gorm:"primaryKey;" json:"key_id"`
 	KeyIDShort  string     `json:"key_id_short"`
 	Fingerprint string     `json:"fingerprint"`
 	CreatedAt   time.Time  `json:"created_at"`
 	Algorithm   string     `json:"algorithm"`
+	BitLength   int        `json:"bit_length"`
+	Curve       string     `json:"curve,omitempty"`
+	CurveOID    string     `gorm:"column:curve_oid" json:"curve_oid,omitempty"`
 	Version     int        `json:"version"`
+	ExpiresAt   *time.Time `gorm:"index" json:"expires_at"`
 	Revoked     bool       `json:"revoked"`
-	Users       []GPGUsers `gorm:"foreignKey:ID;constraint:OnDelete:CASCADE"`
+	Expired     bool       `json:"expired"`
+	Users       []GPGUsers `gorm:"foreignKey:KeyID;references:KeyID;constraint:OnDelete:CASCADE" json:"users"`
 	PublicKey   string     `json:"public_key"`
+	UpdatedAt   time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+	// DeletedAt is the tombstone left by an admin delete.
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+	// Generation counts how often the key was restored after a deletion. Replicating
+	// instances compare it to order deletions and restores without comparing clocks.
+	Generation int `gorm:"not null;default:0" json:"-"`
+
+	Subkeys        []GPGSubkey        `gorm:"foreignKey:ParentKeyID;references:KeyID;constraint:OnDelete:CASCADE" json:"subkeys"`
+	VerifiedEmails []GPGVerifiedEmail `gorm:"foreignKey:KeyID;constraint:OnDelete:CASCADE" json:"-"`
 }
 
 func (GPGPubKeyStore) TableName() string {
 	return "gpg_pub_key_stores"
 }
 
-func ParsePubKey(keyText string) (GPGPubKeyStore, error) {
-	entities, err := openpgp.ReadArmoredKeyRing(bytes.NewBufferString(keyText))
+// verificationRequired reports whether user IDs are only published once their address is verified.
+func verificationRequired() bool {
+	return config.Current.Verification.Enabled
+}
+
+// IsPublished reports whether the user ID with the given email may be served. Without
+// verification every user ID is published.
+func (k *GPGPubKeyStore) IsPublished(email string) bool {
+	if !verificationRequired() {
+		return true
+	}
+	email = strings.ToLower(email)
+	for _, verified := range k.VerifiedEmails {
+		if verified.Email == email {
+			return true
+		}
+	}
+	return false
+}
+
+// PublishedData returns the binary key, re-serialized from its parsed packets, without the
+// user IDs that are not published.
+func (k *GPGPubKeyStore) PublishedData() ([]byte, error) {
+	data, _, err := k.ExportWithUIDs(func(uid *packet.UserId) bool {
+		return k.IsPublished(uid.Email)
+	})
+	return data, err
+}
+
+// subkeyOwners is a subquery of the key IDs owning a subkey with the given ID or fingerprint.
+func subkeyOwners(db *gorm.DB, id string) *gorm.DB {
+	return db.Model(&GPGSubkey{}).Select("parent_key_id").Where("key_id = ? OR key_id_short = ? OR fingerprint = ?", id, id, id)
+}
+
+// keysWithEmail is a subquery of the key IDs with a published address matching the condition.
+// Expired keys are left out when so configured.
+func keysWithEmail(db *gorm.DB, query any, args ...any) *gorm.DB {
+	var keys *gorm.DB
+	if verificationRequired() {
+		keys = db.Model(&GPGVerifiedEmail{}).Select("key_id").Where(query, args...)
+	} else {
+		keys = db.Model(&GPGUsers{}).Select("key_id").Where(query, args...)
+	}
+	if config.Current.GPG.HideExpired {
+		expired := db.Model(&GPGPubKeyStore{}).Select("key_id").Where("expires_at <= ?", time.Now())
+		keys = keys.Where("key_id NOT IN (?)", expired)
+	}
+	return keys
+}
+
+// Entity parses the stored armored key.
+func (k *GPGPubKeyStore) Entity() (*openpgp.Entity, error) {
+	entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(k.PublicKey))
 	if err != nil {
-		return GPGPubKeyStore{}, err
+		return nil, err
+	}
+	if len(entities) == 0 {
+		return nil, fmt.Errorf("no key found")
+	}
+	return entities[0], nil
+}
+
+// KeyExpiry returns when the primary key expires, or the zero time if it never does.
+func KeyExpiry(entity *openpgp.Entity) time.Time {
+	sig, _ := entity.PrimarySelfSignature()
+	if sig == nil || sig.KeyLifetimeSecs == nil || *sig.KeyLifetimeSecs == 0 {
+		return time.Time{}
+	}
+	return entity.PrimaryKey.CreationTime.Add(time.Duration(*sig.KeyLifetimeSecs) * time.Second)
+}
+
+// expiry converts an expiry time to a column value and reports whether it has passed.
+func expiry(expiresAt time.Time, now time.Time) (*time.Time, bool) {
+	if expiresAt.IsZero() {
+		return nil, false
+	}
+	return &expiresAt, !expiresAt.After(now)
+}
+
+type KeyImportStatus string
+
+const (
+	KeyAdded     KeyImportStatus = "added"
+	KeyUpdated   KeyImportStatus = "updated"
+	KeyUnchanged KeyImportStatus = "unchanged"
+	KeyRejected  KeyImportStatus = "rejected"
+	KeyDeleted   KeyImportStatus = "deleted"
+)
+
+// KeyImportResult reports what happened to one key of an uploaded keyring.
+type KeyImportResult struct {
+	Fingerprint string          `json:"fingerprint,omitempty"`
+	Status      KeyImportStatus `json:"status"`
+	Reason      string          `json:"reason,omitempty"`
+	Unverified  []string        `json:"unverified,omitempty"`
+	// Dropped lists the user IDs stripped by the UID policy.
+	Dropped []string `json:"dropped,omitempty"`
+}
+
+// ParsedPubKey is a single key read from an uploaded keyring. Err is set when the key
+// cannot be stored.
+type ParsedPubKey struct {
+	Key         GPGPubKeyStore
+	Fingerprint string
+	Err         error
+
+	data       []byte
+	entity     *openpgp.Entity
+	revocation *packet.Signature
+}
+
+// OpenPGP packet tags that start a new key (RFC 9580 section 5).
+const (
+	packetTagSecretKey = 5
+	packetTagPublicKey = 6
+)
+
+// splitKeyring splits a binary keyring into the packets of each key, keeping them as uploaded.
+// Signatures outside of a key are bare revocation certificates and each get a chunk of their own.
+func splitKeyring(r io.Reader) ([][]byte, error) {
+	keys := [][]byte{}
+	current := &bytes.Buffer{}
+	inKey := false
+
+	packets := packet.NewOpaqueReader(r)
+	for {
+		p, err := packets.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if p.Tag == packetTagPublicKey || p.Tag == packetTagSecretKey || !inKey {
+			if current.Len() > 0 {
+				keys = append(keys, current.Bytes())
+			}
+			current = &bytes.Buffer{}
+			inKey = p.Tag == packetTagPublicKey || p.Tag == packetTagSecretKey
+		}
+		if err := p.Serialize(current); err != nil {
+			return nil, err
+		}
+	}
+	if current.Len() > 0 {
+		keys = append(keys, current.Bytes())
 	}
 
-	// Throw error when there are more than one key
-	if len(entities) > 1 {
-		return GPGPubKeyStore{}, fmt.Errorf("more than one key found")
+	return keys, nil
+}
+
+// subkeyUsage renders the key flags of a subkey binding the way gpg lists them.
+func subkeyUsage(sig *packet.Signature) string {
+	if sig == nil || !sig.FlagsValid {
+		return ""
+	}
+	usage := ""
+	for _, flag := range []struct {
+		set  bool
+		name string
+	}{
+		{sig.FlagCertify, "c"},
+		{sig.FlagSign, "s"},
+		{sig.FlagEncryptCommunications || sig.FlagEncryptStorage, "e"},
+		{sig.FlagAuthenticate, "a"},
+	} {
+		if flag.set {
+			usage += flag.name
+		}
 	}
+	return usage
+}
 
-	entity := entities[0]
+func newSubkey(subkey *openpgp.Subkey, now time.Time) GPGSubkey {
+	algorithm := NewKeyAlgorithm(subkey.PublicKey)
+	s := GPGSubkey{
+		KeyID:       strings.ToLower(subkey.PublicKey.KeyIdString()),
+		KeyIDShort:  strings.ToLower(subkey.PublicKey.KeyIdShortString()),
+		Fingerprint: strings.ToLower(hex.EncodeToString(subkey.PublicKey.Fingerprint)),
+		Algorithm:   algorithm.Name,
+		BitLength:   algorithm.BitLength,
+		Curve:       algorithm.Curve,
+		CurveOID:    algorithm.CurveOID,
+		Usage:       subkeyUsage(subkey.Sig),
+		CreatedAt:   subkey.PublicKey.CreationTime,
+		Revoked:     subkey.Revoked(now),
+	}
+	if subkey.Sig != nil && subkey.Sig.KeyLifetimeSecs != nil && *subkey.Sig.KeyLifetimeSecs > 0 {
+		s.ExpiresAt, s.Expired = expiry(s.CreatedAt.Add(time.Duration(*subkey.Sig.KeyLifetimeSecs)*time.Second), now)
+	}
+	return s
+}
+
+func newPubKeyStore(entity *openpgp.Entity, keyText string) GPGPubKeyStore {
+	now := time.Now()
+	algorithm := NewKeyAlgorithm(entity.PrimaryKey)
 	key := GPGPubKeyStore{
 		KeyID:       strings.ToLower(entity.PrimaryKey.KeyIdString()),
 		KeyIDShort:  strings.ToLower(entity.PrimaryKey.KeyIdShortString()),
 		Fingerprint: strings.ToLower(hex.EncodeToString(entity.PrimaryKey.Fingerprint[:])),
 		CreatedAt:   entity.PrimaryKey.CreationTime,
-		Algorithm:   string(entity.PrimaryKey.PubKeyAlgo),
+		Algorithm:   algorithm.Name,
+		BitLength:   algorithm.BitLength,
+		Curve:       algorithm.Curve,
+		CurveOID:    algorithm.CurveOID,
 		Version:     entity.PrimaryKey.Version,
-		Revoked:     entity.Revoked(time.Now()),
+		Revoked:     entity.Revoked(now),
 		PublicKey:   keyText,
 	}
+	key.ExpiresAt, key.Expired = expiry(KeyExpiry(entity), now)
+
+	for i := range entity.Subkeys {
+		key.Subkeys = append(key.Subkeys, newSubkey(&entity.Subkeys[i], now))
+	}
+
+	key.Users = newUsers(entity, now)
 
+	return key
+}
+
+// newUsers returns the user IDs of a key, primary first and the rest in name order.
+func newUsers(entity *openpgp.Entity, now time.Time) []GPGUsers {
+	primary := entity.PrimaryIdentity()
+
+	users := []GPGUsers{}
 	for _, id := range entity.Identities {
-		key.Users = append(key.Users, GPGUsers{
+		user := GPGUsers{
 			Name:    id.UserId.Name,
 			Email:   strings.ToLower(id.UserId.Email),
+			WKDHash: emailWKDHash(id.UserId.Email),
 			Comment: id.UserId.Comment,
-		})
+			Primary: id == primary,
+			Revoked: id.Revoked(now),
+		}
+		if id.SelfSignature != nil {
+			user.SignedAt = id.SelfSignature.CreationTime
+			if lifetime := id.SelfSignature.KeyLifetimeSecs; lifetime != nil && *lifetime > 0 {
+				expiresAt := entity.PrimaryKey.CreationTime.Add(time.Duration(*lifetime) * time.Second)
+				user.ExpiresAt = &expiresAt
+			}
+		}
+		users = append(users, user)
+	}
+
+	slices.SortStableFunc(users, func(a, b GPGUsers) int {
+		if a.Primary != b.Primary {
+			if a.Primary {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Name+a.Email, b.Name+b.Email)
+	})
+	return users
+}
+
+// parseRevocation reads a bare key revocation certificate.
+func parseRevocation(sig *packet.Signature, data []byte) ParsedPubKey {
+	parsed := ParsedPubKey{data: data, revocation: sig}
+	if sig.IssuerFingerprint != nil {
+		parsed.Fingerprint = strings.ToLower(hex.EncodeToString(sig.IssuerFingerprint))
+	}
+	if sig.SigType != packet.SigTypeKeyRevocation {
+		parsed.Err = fmt.Errorf("signature is not a key revocation")
 	}
+	return parsed
+}
+
+func parseKeyPackets(data []byte) ParsedPubKey {
+	parsed := ParsedPubKey{}
+	if p, err := packet.Read(bytes.NewReader(data)); err == nil {
+		switch p := p.(type) {
+		case *packet.Signature:
+			return parseRevocation(p, data)
+		case *packet.PublicKey:
+			parsed.Fingerprint = strings.ToLower(hex.EncodeToString(p.Fingerprint))
+		}
+	}
+
+	entities, err := openpgp.ReadKeyRing(bytes.NewReader(data))
+	switch {
+	case err != nil:
+		parsed.Err = err
+		return parsed
+	case len(entities) == 0:
+		parsed.Err = fmt.Errorf("unsupported key")
+		return parsed
+	case entities[0].PrivateKey != nil:
+		parsed.Err = fmt.Errorf("private keys are not accepted")
+		return parsed
+	}
+
+	keyText, err := armorKey(data)
+	if err != nil {
+		parsed.Err = err
+		return parsed
+	}
+
+	parsed.Key = newPubKeyStore(entities[0], keyText)
+	parsed.Fingerprint = parsed.Key.Fingerprint
+	parsed.data, parsed.entity = data, entities[0]
+	return parsed
+}
 
-	return key, nil
+func armorKey(data []byte) (string, error) {
+	keyText := &bytes.Buffer{}
+	w, err := armor.Encode(keyText, openpgp.PublicKeyType, nil)
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write(data); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	keyText.WriteString("\n")
+	return keyText.String(), nil
 }
 
-func AddPubKey(db *gorm.DB, key *GPGPubKeyStore) error {
-	err := db.First(key).Error
+func dearmorKey(keyText string) ([]byte, error) {
+	block, err := armor.Decode(strings.NewReader(keyText))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(block.Body)
+}
+
+// ParsePubKeys reads every key of an armored keyring. Keys that cannot be read are returned
+// with Err set, an error is only returned when the keyring itself is unreadable.
+func ParsePubKeys(keyText string) ([]ParsedPubKey, error) {
+	block, err := armor.Decode(strings.NewReader(keyText))
+	if err != nil {
+		return nil, err
+	}
+	if block.Type != openpgp.PublicKeyType {
+		return nil, fmt.Errorf("expected a public key block, got %q", block.Type)
+	}
+	return parseKeyring(block.Body)
+}
+
+// ParseBinaryPubKeys parses a keyring uploaded as raw OpenPGP packets.
+func ParseBinaryPubKeys(data []byte) ([]ParsedPubKey, error) {
+	return parseKeyring(bytes.NewReader(data))
+}
+
+func parseKeyring(r io.Reader) ([]ParsedPubKey, error) {
+	chunks, err := splitKeyring(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("no key found")
+	}
+
+	keys := []ParsedPubKey{}
+	for _, chunk := range chunks {
+		keys = append(keys, parseKeyPackets(chunk))
+	}
+	return keys, nil
+}
+
+// saveKey updates a stored key, replacing its user IDs and subkeys with the ones of the new
+// version. The UID policy applies to the whole key, and addresses verified on user IDs it
+// strips are forgotten. A key it would leave without user IDs is not saved.
+func saveKey(db *gorm.DB, key *GPGPubKeyStore) error {
+	owned, err := stripStoredUIDs(key)
+	if err != nil {
+		return err
+	}
+	if !owned {
+		return errNoOwnedUID
+	}
+	if err := db.Where("key_id = ?", key.KeyID).Delete(&GPGUsers{}).Error; err != nil {
+		return err
+	}
+	if err := db.Where("parent_key_id = ?", key.KeyID).Delete(&GPGSubkey{}).Error; err != nil {
+		return err
+	}
+	if err := db.Unscoped().Save(key).Error; err != nil {
+		return err
+	}
+	emails := db.Model(&GPGUsers{}).Select("email").Where("key_id = ?", key.KeyID)
+	return db.Where("key_id = ? AND email NOT IN (?)", key.KeyID, emails).Delete(&GPGVerifiedEmail{}).Error
+}
+
+// addPubKey stores a new key or merges it into the stored copy, so an upload can only ever
+// add user IDs, subkeys and signatures. Deleted keys are refused or restored depending on
+// the configured policy.
+func addPubKey(db *gorm.DB, parsed *ParsedPubKey) (KeyImportStatus, string, error) {
+	existing := GPGPubKeyStore{}
+	err := db.Unscoped().Where("key_id = ?", parsed.Key.KeyID).First(&existing).Error
+	if err == gorm.ErrRecordNotFound {
+		return KeyAdded, "", db.Create(&parsed.Key).Error
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	if existing.Fingerprint != parsed.Key.Fingerprint {
+		return KeyRejected, "key ID collides with a stored key", nil
+	}
+
+	deleted := existing.DeletedAt.Valid
+	if deleted && config.Current.GPG.RefuseDeletedKeys {
+		return KeyRejected, "key has been deleted", nil
+	}
+
+	merged, err := mergePubKey(&existing, parsed)
 	if err != nil {
+		return "", "", err
+	}
+	if merged == nil {
+		// Nothing new was uploaded, but the stored copy may predate the UID policy.
+		stripped := existing
+		if _, err := stripStoredUIDs(&stripped); err != nil {
+			return "", "", err
+		}
+		if !deleted && stripped.PublicKey == existing.PublicKey {
+			return KeyUnchanged, "", nil
+		}
+		merged = &stripped
+	}
+	if deleted {
+		merged.DeletedAt = gorm.DeletedAt{}
+		merged.Generation = existing.Generation + 1
+	}
+	return saveMerged(db, merged)
+}
+
+// saveMerged stores a key merged with an upload, rejecting the upload when the UID policy
+// leaves nothing of the key.
+func saveMerged(db *gorm.DB, key *GPGPubKeyStore) (KeyImportStatus, string, error) {
+	if err := saveKey(db, key); err != nil {
+		if errors.Is(err, errNoOwnedUID) {
+			return KeyRejected, err.Error(), nil
+		}
+		return "", "", err
+	}
+	return KeyUpdated, "", nil
+}
+
+// addRevocation merges a bare revocation certificate into the key that issued it.
+func addRevocation(db *gorm.DB, parsed *ParsedPubKey) (KeyImportStatus, string, error) {
+	sig := parsed.revocation
+	query := db
+	switch {
+	case sig.IssuerFingerprint != nil:
+		query = query.Where("fingerprint = ?", strings.ToLower(hex.EncodeToString(sig.IssuerFingerprint)))
+	case sig.IssuerKeyId != nil:
+		query = query.Where("key_id = ?", fmt.Sprintf("%016x", *sig.IssuerKeyId))
+	default:
+		return KeyRejected, "revocation has no issuer", nil
+	}
+
+	existing := GPGPubKeyStore{}
+	if err := query.First(&existing).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return db.Create(key).Error
-		} else {
-			return err
+			return KeyRejected, "revoked key not found", nil
 		}
-	} else {
-		return db.Save(key).Error
+		return "", "", err
+	}
+	parsed.Fingerprint = existing.Fingerprint
+
+	merged, err := mergeRevocation(&existing, parsed)
+	if err != nil {
+		return KeyRejected, err.Error(), nil
+	}
+	if merged == nil {
+		return KeyUnchanged, "", nil
 	}
+	return saveMerged(db, merged)
 }
 
-func LookupPubKey(db *gorm.DB, searchStr string) (*GPGPubKeyStore, error) {
-	keys := []GPGPubKeyStore{}
-	searchStr = strings.ToLower(searchStr)
+// DeletePubKey soft deletes a key, leaving a tombstone behind. The key counts as changed, so
+// the deletion reaches replicating peers.
+func DeletePubKey(db *gorm.DB, fingerprint string) error {
+	fingerprint = strings.TrimPrefix(strings.ToLower(fingerprint), constants.GPGFingerprintPrefix)
+	now := time.Now()
+	result := db.Model(&GPGPubKeyStore{}).Where("fingerprint = ?", fingerprint).
+		Updates(map[string]any{"deleted_at": now, "updated_at": now})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
 
-	err := db.Preload("Users").Joins("JOIN gpg_users ON gpg_users.id = gpg_pub_key_stores.key_id").
-		Where("gpg_pub_key_stores.key_id = ? OR gpg_pub_key_stores.key_id_short = ? OR gpg_pub_key_stores.fingerprint = ? OR gpg_users.email = ?", searchStr, searchStr, strings.TrimPrefix(searchStr, constants.GPGFingerprintPrefix), searchStr).
-		Find(&keys).Error
+// ImportPubKeys stores the parsed keys in a single transaction and reports the outcome for
+// each of them. An error is only returned when the store fails, rolling back every key.
+func ImportPubKeys(db *gorm.DB, keys []ParsedPubKey) ([]KeyImportResult, error) {
+	results := []KeyImportResult{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for i := range keys {
+			result := KeyImportResult{Fingerprint: keys[i].Fingerprint}
+			if keys[i].Err == nil {
+				dropped, err := applyUIDPolicy(&keys[i])
+				if err != nil {
+					return err
+				}
+				result.Dropped = dropped
+			}
+			if keys[i].Err != nil {
+				result.Status, result.Reason, result.Dropped = KeyRejected, keys[i].Err.Error(), nil
+				results = append(results, result)
+				continue
+			}
+
+			add := addPubKey
+			if keys[i].revocation != nil {
+				add = addRevocation
+			}
+			status, reason, err := add(tx, &keys[i])
+			if err != nil {
+				return err
+			}
+			result.Fingerprint, result.Status, result.Reason = keys[i].Fingerprint, status, reason
+			results = append(results, result)
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	return results, nil
+}
 
-	if len(keys) > 1 {
-		return nil, fmt.Errorf("more than one key found")
+// LookupPubKey returns every key whose key ID, fingerprint or user ID email matches the
+// search exactly.
+func LookupPubKey(db *gorm.DB, searchStr string) ([]GPGPubKeyStore, error) {
+	keys := []GPGPubKeyStore{}
+	searchStr = strings.ToLower(strings.TrimSpace(searchStr))
+	id := strings.TrimPrefix(searchStr, constants.GPGFingerprintPrefix)
+
+	users := keysWithEmail(db, "email = ?", searchStr)
+	err := db.Preload("Users").Preload("Subkeys").Preload("VerifiedEmails").
+		Where("key_id = ? OR key_id_short = ? OR fingerprint = ? OR key_id IN (?) OR key_id IN (?)", id, id, id, subkeyOwners(db, id), users).
+		Order("fingerprint").
+		Find(&keys).Error
+	if err != nil {
+		return nil, err
 	}
 
 	if len(keys) == 0 {
 		return nil, gorm.ErrRecordNotFound
 	}
 
-	return &keys[0], nil
+	return keys, nil
+}
+
+// ArmorKeyring joins the stored keys into a single armored keyring.
+func ArmorKeyring(keys []GPGPubKeyStore) (string, error) {
+	data, err := BinaryKeyring(keys)
+	if err != nil {
+		return "", err
+	}
+	return armorKey(data)
+}
+
+// BinaryKeyring concatenates the published binary form of the keys.
+func BinaryKeyring(keys []GPGPubKeyStore) ([]byte, error) {
+	out := &bytes.Buffer{}
+	for i := range keys {
+		data, err := keys[i].PublishedData()
+		if err != nil {
+			return nil, fmt.Errorf("failed to export key %s: %w", keys[i].Fingerprint, err)
+		}
+		out.Write(data)
+	}
+	return out.Bytes(), nil
+}
+
+// ListPubKeysByWKDHash returns the keys with a user ID email in domain whose local part has
+// the given WKD hash.
+func ListPubKeysByWKDHash(db *gorm.DB, domain, hash string) ([]GPGPubKeyStore, error) {
+	keys := []GPGPubKeyStore{}
+	pattern := "%@" + escapeLike(strings.ToLower(domain))
+
+	emails := db.Model(&GPGUsers{}).Select("email").Where(`wkd_hash = ? AND email LIKE ? ESCAPE '\'`, hash, pattern)
+	users := keysWithEmail(db, "email IN (?)", emails)
+	err := db.Preload("Users").Preload("VerifiedEmails").Where("key_id IN (?)", users).Order("fingerprint").Find(&keys).Error
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// escapeLike escapes the LIKE wildcards in s.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// SearchPubKeys finds keys for the HKP index operation. Searches starting with 0x match
+// key IDs and fingerprints, anything else is a substring match on the user IDs unless
+// exact is set.
+func SearchPubKeys(db *gorm.DB, searchStr string, exact bool) ([]GPGPubKeyStore, error) {
+	keys := []GPGPubKeyStore{}
+	if exact {
+		keys, err := LookupPubKey(db, searchStr)
+		if err == gorm.ErrRecordNotFound {
+			return []GPGPubKeyStore{}, nil
+		}
+		return keys, err
+	}
+
+	searchStr = strings.ToLower(strings.TrimSpace(searchStr))
+
+	query := db.Preload("Users").Preload("VerifiedEmails")
+	if id, ok := strings.CutPrefix(searchStr, constants.GPGFingerprintPrefix); ok {
+		query = query.Where("key_id = ? OR key_id_short = ? OR fingerprint = ? OR key_id IN (?)", id, id, id, subkeyOwners(db, id))
+	} else {
+		pattern := "%" + escapeLike(searchStr) + "%"
+		// Names are not verified, so they are only searched when every user ID is published.
+		match := db.Where(`LOWER(email) LIKE ? ESCAPE '\'`, pattern)
+		if !verificationRequired() {
+			match = match.Or(`LOWER(name) LIKE ? ESCAPE '\'`, pattern)
+		}
+		users := keysWithEmail(db, match)
+		query = query.Where("key_id IN (?)", users)
+	}
+
+	if err := query.Order("fingerprint").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// PubKeyFilter narrows down ListPubKeys. Unset fields match every key.
+type PubKeyFilter struct {
+	Email   string
+	Domain  string
+	Revoked *bool
+	Expired *bool
+}
+
+// ListPubKeys returns up to limit keys ordered by fingerprint, starting after the cursor
+// fingerprint, along with the cursor of the next page or "" on the last one.
+func ListPubKeys(db *gorm.DB, filter PubKeyFilter, cursor string, limit int) ([]GPGPubKeyStore, string, error) {
+	query := db.Preload("Users").Preload("Subkeys").Preload("VerifiedEmails")
+	if filter.Email != "" {
+		query = query.Where("key_id IN (?)", keysWithEmail(db, "email = ?", strings.ToLower(filter.Email)))
+	}
+	if filter.Domain != "" {
+		pattern := "%@" + escapeLike(strings.ToLower(filter.Domain))
+		query = query.Where("key_id IN (?)", keysWithEmail(db, `email LIKE ? ESCAPE '\'`, pattern))
+	}
+	if filter.Revoked != nil {
+		query = query.Where("revoked = ?", *filter.Revoked)
+	}
+	if filter.Expired != nil {
+		// Compared against the expiry date, so keys that expired since the last status refresh count.
+		if *filter.Expired {
+			query = query.Where("expires_at <= ?", time.Now())
+		} else {
+			query = query.Where("expires_at IS NULL OR expires_at > ?", time.Now())
+		}
+	}
+	if cursor != "" {
+		query = query.Where("fingerprint > ?", strings.ToLower(cursor))
+	}
+
+	keys := []GPGPubKeyStore{}
+	if err := query.Order("fingerprint").Limit(limit + 1).Find(&keys).Error; err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if len(keys) > limit {
+		keys = keys[:limit]
+		next = keys[limit-1].Fingerprint
+	}
+	return keys, next, nil
+}
+
+// UnverifiedEmails returns the addresses of the key's user IDs that still need verifying.
+func UnverifiedEmails(db *gorm.DB, key *GPGPubKeyStore) ([]string, error) {
+	verified := []string{}
+	if err := db.Model(&GPGVerifiedEmail{}).Where("key_id = ?", key.KeyID).Pluck("email", &verified).Error; err != nil {
+		return nil, err
+	}
+
+	emails := []string{}
+	for _, user := range key.Users {
+		if user.Email != "" && !slices.Contains(verified, user.Email) && !slices.Contains(emails, user.Email) {
+			emails = append(emails, user.Email)
+		}
+	}
+	return emails, nil
+}
+
+// VerifyEmail publishes the user IDs with the given email on the key.
+func VerifyEmail(db *gorm.DB, fingerprint, email string) error {
+	key := GPGPubKeyStore{}
+	if err := db.Where("fingerprint = ?", strings.ToLower(fingerprint)).First(&key).Error; err != nil {
+		return err
+	}
+
+	entity, err := key.Entity()
+	if err != nil {
+		return err
+	}
+
+	email = strings.ToLower(email)
+	if email == "" {
+		return gorm.ErrRecordNotFound
+	}
+	found := false
+	for _, identity := range entity.Identities {
+		if strings.ToLower(identity.UserId.Email) == email {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return gorm.ErrRecordNotFound
+	}
+
+	verified := GPGVerifiedEmail{KeyID: key.KeyID, Email: email}
+	result := db.Where("key_id = ? AND email = ?", key.KeyID, email).Attrs(GPGVerifiedEmail{VerifiedAt: time.Now()}).FirstOrCreate(&verified)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return result.Error
+	}
+	return touchPubKey(db, key.KeyID)
+}
+
+// touchPubKey marks a key as changed when something stored beside it, such as its verified
+// addresses, changes what is published.
+func touchPubKey(db *gorm.DB, keyID string) error {
+	return db.Unscoped().Model(&GPGPubKeyStore{}).Where("key_id = ?", keyID).Update("updated_at", time.Now()).Error
+}
+
+// RefreshKeyStatus recomputes the revocation and expiry of every stored key, its user IDs
+// and subkeys, since signatures and expiry dates take effect long after an upload. It
+// returns the number of keys whose status changed.
+func RefreshKeyStatus(db *gorm.DB, now time.Time) (int, error) {
+	keys := []GPGPubKeyStore{}
+	if err := db.Preload("Users").Preload("Subkeys").Find(&keys).Error; err != nil {
+		return 0, err
+	}
+
+	changed := 0
+	for i := range keys {
+		entity, err := keys[i].Entity()
+		if err != nil {
+			slog.Warn("Skipping unparsable key", "fingerprint", keys[i].Fingerprint, "error", err)
+			continue
+		}
+
+		key := keys[i]
+		key.Revoked = entity.Revoked(now)
+		key.ExpiresAt, key.Expired = expiry(KeyExpiry(entity), now)
+		key.Users = newUsers(entity, now)
+		key.Subkeys = nil
+		for j := range entity.Subkeys {
+			key.Subkeys = append(key.Subkeys, newSubkey(&entity.Subkeys[j], now))
+		}
+		if keyStatus(&key) == keyStatus(&keys[i]) {
+			continue
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			return saveKey(tx, &key)
+		})
+		if errors.Is(err, errNoOwnedUID) {
+			// EnforceUIDPolicy removes such keys, saving would only write the user IDs back.
+			slog.Warn("Skipping key without a user ID in the configured domains", "fingerprint", key.Fingerprint)
+			continue
+		}
+		if err != nil {
+			return changed, err
+		}
+		changed++
+	}
+	return changed, nil
+}
+
+// keyStatus summarises the revocation and expiry state of a key for comparison.
+func keyStatus(k *GPGPubKeyStore) string {
+	var b strings.Builder
+	status := func(revoked, expired bool, expiresAt *time.Time) {
+		fmt.Fprintf(&b, "%t:%t:", revoked, expired)
+		if expiresAt != nil {
+			fmt.Fprint(&b, expiresAt.Unix())
+		}
+		b.WriteString(";")
+	}
+
+	status(k.Revoked, k.Expired, k.ExpiresAt)
+	for _, user := range k.Users {
+		status(user.Revoked, false, user.ExpiresAt)
+	}
+	for _, subkey := range k.Subkeys {
+		status(subkey.Revoked, subkey.Expired, subkey.ExpiresAt)
+	}
+	return b.String()
 }
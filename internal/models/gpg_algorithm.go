@@ -0,0 +1,96 @@
+package models
+
+import (
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+var algorithmNames = map[packet.PublicKeyAlgorithm]string{
+	packet.PubKeyAlgoRSA:            "RSA",
+	packet.PubKeyAlgoRSAEncryptOnly: "RSA",
+	packet.PubKeyAlgoRSASignOnly:    "RSA",
+	packet.PubKeyAlgoElGamal:        "ElGamal",
+	packet.PubKeyAlgoDSA:            "DSA",
+	packet.PubKeyAlgoECDH:           "ECDH",
+	packet.PubKeyAlgoECDSA:          "ECDSA",
+	packet.PubKeyAlgoEdDSA:          "EdDSA",
+	packet.PubKeyAlgoX25519:         "X25519",
+	packet.PubKeyAlgoX448:           "X448",
+	packet.PubKeyAlgoEd25519:        "Ed25519",
+	packet.PubKeyAlgoEd448:          "Ed448",
+}
+
+type curveInfo struct {
+	name      string
+	oid       string
+	bitLength int
+}
+
+// curves maps the curve reported by go-crypto to the names and OIDs used by GnuPG.
+// Curve25519 and Curve448 have separate signing and encryption variants.
+var curves = map[packet.Curve]curveInfo{
+	packet.CurveNistP256:      {"nistp256", "1.2.840.10045.3.1.7", 256},
+	packet.CurveNistP384:      {"nistp384", "1.3.132.0.34", 384},
+	packet.CurveNistP521:      {"nistp521", "1.3.132.0.35", 521},
+	packet.CurveSecP256k1:     {"secp256k1", "1.3.132.0.10", 256},
+	packet.CurveBrainpoolP256: {"brainpoolP256r1", "1.3.36.3.3.2.8.1.1.7", 256},
+	packet.CurveBrainpoolP384: {"brainpoolP384r1", "1.3.36.3.3.2.8.1.1.11", 384},
+	packet.CurveBrainpoolP512: {"brainpoolP512r1", "1.3.36.3.3.2.8.1.1.13", 512},
+}
+
+var (
+	curveEd25519 = curveInfo{"ed25519", "1.3.6.1.4.1.11591.15.1", 255}
+	curveCv25519 = curveInfo{"cv25519", "1.3.6.1.4.1.3029.1.5.1", 255}
+	curveEd448   = curveInfo{"ed448", "1.3.101.113", 448}
+	curveCv448   = curveInfo{"cv448", "1.3.101.111", 448}
+)
+
+// KeyAlgorithm describes the public key algorithm of a primary key or subkey.
+type KeyAlgorithm struct {
+	Name      string
+	BitLength int
+	// Curve and CurveOID are only set for elliptic curve keys. Keys using the dedicated
+	// X25519, X448, Ed25519 and Ed448 algorithms carry no OID.
+	Curve    string
+	CurveOID string
+}
+
+func NewKeyAlgorithm(pk *packet.PublicKey) KeyAlgorithm {
+	a := KeyAlgorithm{Name: algorithmNames[pk.PubKeyAlgo]}
+	if a.Name == "" {
+		a.Name = "unknown"
+	}
+
+	curve, err := pk.Curve()
+	if err != nil {
+		bitLength, _ := pk.BitLength()
+		a.BitLength = int(bitLength)
+		return a
+	}
+
+	var info curveInfo
+	switch {
+	case curve == packet.Curve25519 && pk.PubKeyAlgo == packet.PubKeyAlgoEdDSA:
+		info = curveEd25519
+	case curve == packet.Curve25519 && pk.PubKeyAlgo == packet.PubKeyAlgoECDH:
+		info = curveCv25519
+	case curve == packet.Curve448 && pk.PubKeyAlgo == packet.PubKeyAlgoEdDSA:
+		info = curveEd448
+	case curve == packet.Curve448 && pk.PubKeyAlgo == packet.PubKeyAlgoECDH:
+		info = curveCv448
+	case pk.PubKeyAlgo == packet.PubKeyAlgoEd25519:
+		info = curveInfo{curveEd25519.name, "", curveEd25519.bitLength}
+	case pk.PubKeyAlgo == packet.PubKeyAlgoX25519:
+		info = curveInfo{curveCv25519.name, "", curveCv25519.bitLength}
+	case pk.PubKeyAlgo == packet.PubKeyAlgoEd448:
+		info = curveInfo{curveEd448.name, "", curveEd448.bitLength}
+	case pk.PubKeyAlgo == packet.PubKeyAlgoX448:
+		info = curveInfo{curveCv448.name, "", curveCv448.bitLength}
+	default:
+		info = curves[curve]
+	}
+
+	a.Curve = info.name
+	a.CurveOID = info.oid
+	a.BitLength = info.bitLength
+	return a
+}
@@ -0,0 +1,257 @@
+package models
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// OpenPGP packet tags grouped by the merge (RFC 9580 section 5).
+const (
+	packetTagSignature     = 2
+	packetTagTrust         = 12
+	packetTagUserID        = 13
+	packetTagPublicSubkey  = 14
+	packetTagUserAttribute = 17
+)
+
+// packetGroup is a key, subkey or user ID packet followed by the signatures over it.
+type packetGroup struct {
+	packet *packet.OpaquePacket
+	sigs   []*packet.OpaquePacket
+
+	// uid is set for user ID packets.
+	uid *packet.UserId
+}
+
+// keyPackets is a transferable public key split into the parts the merge works on.
+type keyPackets struct {
+	primary packetGroup
+	uids    []packetGroup
+	subkeys []packetGroup
+}
+
+func packetID(p *packet.OpaquePacket) string {
+	return string([]byte{p.Tag}) + string(p.Contents)
+}
+
+func (g *packetGroup) merge(src packetGroup) {
+	seen := map[string]bool{}
+	for _, sig := range g.sigs {
+		seen[packetID(sig)] = true
+	}
+	for _, sig := range src.sigs {
+		if !seen[packetID(sig)] {
+			seen[packetID(sig)] = true
+			g.sigs = append(g.sigs, sig)
+		}
+	}
+}
+
+func mergeGroups(dst, src []packetGroup) []packetGroup {
+	index := map[string]int{}
+	for i := range dst {
+		index[packetID(dst[i].packet)] = i
+	}
+	for _, group := range src {
+		if i, ok := index[packetID(group.packet)]; ok {
+			dst[i].merge(group)
+			continue
+		}
+		index[packetID(group.packet)] = len(dst)
+		dst = append(dst, group)
+	}
+	return dst
+}
+
+// merge adds the user IDs, subkeys and signatures of src that k does not have yet.
+func (k *keyPackets) merge(src *keyPackets) {
+	k.primary.merge(src.primary)
+	k.uids = mergeGroups(k.uids, src.uids)
+	k.subkeys = mergeGroups(k.subkeys, src.subkeys)
+}
+
+func (k *keyPackets) serialize() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	write := func(g packetGroup) error {
+		if err := g.packet.Serialize(buf); err != nil {
+			return err
+		}
+		for _, sig := range g.sigs {
+			if err := sig.Serialize(buf); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := write(k.primary); err != nil {
+		return nil, err
+	}
+	for _, groups := range [][]packetGroup{k.uids, k.subkeys} {
+		for _, group := range groups {
+			if err := write(group); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// readKeyPackets splits the packets of a single key. User IDs and subkeys that did not
+// validate when entity was parsed are dropped so the merge cannot carry them over.
+func readKeyPackets(data []byte, entity *openpgp.Entity) (*keyPackets, error) {
+	validSubkeys := map[string]bool{}
+	for _, subkey := range entity.Subkeys {
+		validSubkeys[string(subkey.PublicKey.Fingerprint)] = true
+	}
+
+	k := &keyPackets{}
+	var current *packetGroup
+	packets := packet.NewOpaqueReader(bytes.NewReader(data))
+	for {
+		p, err := packets.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch p.Tag {
+		case packetTagPublicKey:
+			k.primary = packetGroup{packet: p}
+			current = &k.primary
+		case packetTagSignature:
+			if current != nil {
+				current.sigs = append(current.sigs, p)
+			}
+		case packetTagUserID:
+			current = nil
+			if parsed, err := p.Parse(); err == nil {
+				if uid, ok := parsed.(*packet.UserId); ok && entity.Identities[uid.Id] != nil {
+					k.uids = append(k.uids, packetGroup{packet: p, uid: uid})
+					current = &k.uids[len(k.uids)-1]
+				}
+			}
+		case packetTagUserAttribute:
+			k.uids = append(k.uids, packetGroup{packet: p})
+			current = &k.uids[len(k.uids)-1]
+		case packetTagPublicSubkey:
+			current = nil
+			if parsed, err := p.Parse(); err == nil {
+				if subkey, ok := parsed.(*packet.PublicKey); ok && validSubkeys[string(subkey.Fingerprint)] {
+					k.subkeys = append(k.subkeys, packetGroup{packet: p})
+					current = &k.subkeys[len(k.subkeys)-1]
+				}
+			}
+		case packetTagTrust:
+			// Trust packets are local to the exporting keyring and never stored.
+		}
+	}
+	return k, nil
+}
+
+// mergeStored applies merge to the packets of a stored key. The returned key is nil when the
+// merge adds nothing new.
+func mergeStored(existing *GPGPubKeyStore, merge func(*keyPackets) error) (*GPGPubKeyStore, error) {
+	existingEntity, err := existing.Entity()
+	if err != nil {
+		return nil, err
+	}
+	existingData, err := dearmorKey(existing.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	dst, err := readKeyPackets(existingData, existingEntity)
+	if err != nil {
+		return nil, err
+	}
+	before, err := dst.serialize()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := merge(dst); err != nil {
+		return nil, err
+	}
+	after, err := dst.serialize()
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.Equal(before, after) {
+		return nil, nil
+	}
+
+	merged := parseKeyPackets(after)
+	if merged.Err != nil {
+		return nil, merged.Err
+	}
+	merged.Key.CreatedAt, merged.Key.DeletedAt, merged.Key.Generation = existing.CreatedAt, existing.DeletedAt, existing.Generation
+	return &merged.Key, nil
+}
+
+// mergePubKey merges the packets of an uploaded key into the stored one.
+func mergePubKey(existing *GPGPubKeyStore, incoming *ParsedPubKey) (*GPGPubKeyStore, error) {
+	return mergeStored(existing, func(dst *keyPackets) error {
+		src, err := readKeyPackets(incoming.data, incoming.entity)
+		if err != nil {
+			return err
+		}
+		dst.merge(src)
+		return nil
+	})
+}
+
+// mergeRevocation verifies a bare revocation certificate and adds it to the stored key.
+func mergeRevocation(existing *GPGPubKeyStore, incoming *ParsedPubKey) (*GPGPubKeyStore, error) {
+	entity, err := existing.Entity()
+	if err != nil {
+		return nil, err
+	}
+	if err := entity.PrimaryKey.VerifyRevocationSignature(incoming.revocation); err != nil {
+		return nil, fmt.Errorf("invalid revocation signature")
+	}
+
+	sig, err := packet.NewOpaqueReader(bytes.NewReader(incoming.data)).Next()
+	if err != nil {
+		return nil, err
+	}
+	return mergeStored(existing, func(dst *keyPackets) error {
+		dst.primary.merge(packetGroup{sigs: []*packet.OpaquePacket{sig}})
+		return nil
+	})
+}
+
+// ExportWithUIDs returns the binary key with only the user IDs accepted by keep, along with
+// the number of user IDs kept.
+func (k *GPGPubKeyStore) ExportWithUIDs(keep func(*packet.UserId) bool) ([]byte, int, error) {
+	entity, err := k.Entity()
+	if err != nil {
+		return nil, 0, err
+	}
+	data, err := dearmorKey(k.PublicKey)
+	if err != nil {
+		return nil, 0, err
+	}
+	packets, err := readKeyPackets(data, entity)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	uids := []packetGroup{}
+	for _, group := range packets.uids {
+		if group.uid != nil && keep(group.uid) {
+			uids = append(uids, group)
+		}
+	}
+
+	packets.uids = uids
+	data, err = packets.serialize()
+	return data, len(uids), err
+}
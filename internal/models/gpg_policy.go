@@ -0,0 +1,164 @@
+package models
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/hibare/DomainHQ/internal/config"
+	"github.com/hibare/DomainHQ/internal/constants"
+	"gorm.io/gorm"
+)
+
+// errNoOwnedUID rejects a key the UID policy would leave without user IDs.
+var errNoOwnedUID = errors.New("no user ID in the configured domains")
+
+// OwnedEmail reports whether the address is in one of the configured domains.
+func OwnedEmail(email string) bool {
+	email = strings.TrimSpace(email)
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	_, ok := config.Current.WebFinger.LookupDomain(email[at+1:])
+	return ok
+}
+
+// ownedUID reports whether the user ID has an email in one of the configured domains.
+func ownedUID(uid *packet.UserId) bool {
+	return OwnedEmail(uid.Email)
+}
+
+// applyUIDPolicy strips the user IDs outside the configured domains from a parsed key when
+// the UID policy asks for it, and returns the stripped user IDs. A key left without user IDs
+// gets Err set. User attributes carry no email and are kept.
+func applyUIDPolicy(parsed *ParsedPubKey) ([]string, error) {
+	if config.Current.GPG.UIDPolicy != constants.UIDPolicyStrip || parsed.Err != nil || parsed.entity == nil {
+		return nil, nil
+	}
+
+	packets, err := readKeyPackets(parsed.data, parsed.entity)
+	if err != nil {
+		return nil, err
+	}
+
+	kept, owned := []packetGroup{}, 0
+	dropped := []string{}
+	for _, group := range packets.uids {
+		switch {
+		case group.uid == nil:
+			kept = append(kept, group)
+		case ownedUID(group.uid):
+			kept = append(kept, group)
+			owned++
+		default:
+			dropped = append(dropped, group.uid.Id)
+		}
+	}
+	if len(dropped) == 0 {
+		return nil, nil
+	}
+	if owned == 0 {
+		parsed.Err = errNoOwnedUID
+		return dropped, nil
+	}
+
+	packets.uids = kept
+	data, err := packets.serialize()
+	if err != nil {
+		return nil, err
+	}
+
+	stripped := parseKeyPackets(data)
+	if stripped.Err != nil {
+		return nil, stripped.Err
+	}
+	*parsed = stripped
+	return dropped, nil
+}
+
+// stripStoredUIDs applies the UID policy to a key about to be stored, so user IDs kept from
+// the stored copy by a merge go as well. It reports false, leaving the key untouched, when no
+// user ID in the configured domains would be left.
+func stripStoredUIDs(key *GPGPubKeyStore) (bool, error) {
+	if config.Current.GPG.UIDPolicy != constants.UIDPolicyStrip {
+		return true, nil
+	}
+
+	data, err := dearmorKey(key.PublicKey)
+	if err != nil {
+		return false, err
+	}
+	parsed := parseKeyPackets(data)
+	if parsed.Err != nil {
+		return false, parsed.Err
+	}
+
+	dropped, err := applyUIDPolicy(&parsed)
+	if err != nil {
+		return false, err
+	}
+	if parsed.Err != nil {
+		return false, nil
+	}
+	if len(dropped) > 0 {
+		parsed.Key.CreatedAt, parsed.Key.DeletedAt, parsed.Key.Generation = key.CreatedAt, key.DeletedAt, key.Generation
+		*key = parsed.Key
+	}
+	return true, nil
+}
+
+// EnforceUIDPolicy applies the UID policy to the keys stored before it was set. Keys left
+// without a user ID in the configured domains are removed outright rather than deleted, so
+// no tombstone replicates to peers with another policy. It returns the number of keys changed
+// or removed.
+func EnforceUIDPolicy(db *gorm.DB) (int, error) {
+	if config.Current.GPG.UIDPolicy != constants.UIDPolicyStrip {
+		return 0, nil
+	}
+
+	keys := []GPGPubKeyStore{}
+	if err := db.Find(&keys).Error; err != nil {
+		return 0, err
+	}
+
+	changed := 0
+	for i := range keys {
+		key := keys[i]
+		owned, err := stripStoredUIDs(&key)
+		if err != nil {
+			slog.Warn("Skipping unparsable key", "fingerprint", key.Fingerprint, "error", err)
+			continue
+		}
+		if owned && key.PublicKey == keys[i].PublicKey {
+			continue
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if owned {
+				return saveKey(tx, &key)
+			}
+			slog.Info("Removing key without a user ID in the configured domains", "fingerprint", key.Fingerprint)
+			return purgePubKey(tx, key.KeyID)
+		})
+		if err != nil {
+			return changed, err
+		}
+		changed++
+	}
+	return changed, nil
+}
+
+// purgePubKey removes a key and everything stored beside it without leaving a tombstone.
+func purgePubKey(db *gorm.DB, keyID string) error {
+	for _, model := range []any{&GPGUsers{}, &GPGVerifiedEmail{}} {
+		if err := db.Where("key_id = ?", keyID).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	if err := db.Where("parent_key_id = ?", keyID).Delete(&GPGSubkey{}).Error; err != nil {
+		return err
+	}
+	return db.Unscoped().Where("key_id = ?", keyID).Delete(&GPGPubKeyStore{}).Error
+}
@@ -0,0 +1,244 @@
+package models
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hibare/DomainHQ/internal/config"
+	"gorm.io/gorm"
+)
+
+// ErrInvalidCursor is returned for a change feed cursor that was not issued by ListKeyChanges.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// KeyChange is the state of a key as exchanged between replicating instances. PublicKey is
+// the full stored key, unverified user IDs included, so peers can merge it like an upload.
+// UpdatedAt and DeletedAt are informational, peers resolve conflicts by Generation.
+type KeyChange struct {
+	Fingerprint    string     `json:"fingerprint"`
+	PublicKey      string     `json:"public_key"`
+	VerifiedEmails []string   `json:"verified_emails"`
+	Generation     int        `json:"generation"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	DeletedAt      *time.Time `json:"deleted_at,omitempty"`
+}
+
+// ReplicationState tracks how far the changes of a peer have been pulled.
+type ReplicationState struct {
+	Peer   string `gorm:"primaryKey" json:"peer"`
+	Cursor string `json:"cursor"`
+	// Applied counts the changes pulled from the peer.
+	Applied       int64      `json:"applied"`
+	LastAttemptAt *time.Time `json:"last_attempt_at"`
+	// CaughtUpAt is when the last pull reached the end of the peer's feed.
+	CaughtUpAt *time.Time `json:"caught_up_at"`
+	LastError  string     `json:"last_error"`
+}
+
+func (ReplicationState) TableName() string {
+	return "replication_states"
+}
+
+// changeCursor encodes the position of a key in the change feed.
+func changeCursor(key *GPGPubKeyStore) string {
+	return key.UpdatedAt.UTC().Format(time.RFC3339Nano) + "_" + key.KeyID
+}
+
+func parseChangeCursor(cursor string) (time.Time, string, error) {
+	at, keyID, ok := strings.Cut(cursor, "_")
+	if !ok {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	updatedAt, err := time.Parse(time.RFC3339Nano, at)
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	return updatedAt, keyID, nil
+}
+
+// ListKeyChanges returns the keys changed after the cursor and before settled, deleted keys
+// included, ordered by when they changed. Changes stamped after settled are left for a later
+// call, since a transaction stamped before them may not have committed yet and the cursor
+// would move past it. It also returns the cursor to continue from and whether more changes
+// are waiting; the cursor is returned unchanged when there is nothing new.
+func ListKeyChanges(db *gorm.DB, cursor string, limit int, settled time.Time) ([]KeyChange, string, bool, error) {
+	query := db.Unscoped().Preload("VerifiedEmails").Where("updated_at < ?", settled).Order("updated_at, key_id").Limit(limit + 1)
+	if cursor != "" {
+		updatedAt, keyID, err := parseChangeCursor(cursor)
+		if err != nil {
+			return nil, "", false, err
+		}
+		query = query.Where("updated_at > ? OR (updated_at = ? AND key_id > ?)", updatedAt, updatedAt, keyID)
+	}
+
+	keys := []GPGPubKeyStore{}
+	if err := query.Find(&keys).Error; err != nil {
+		return nil, "", false, err
+	}
+
+	more := len(keys) > limit
+	if more {
+		keys = keys[:limit]
+	}
+
+	changes := []KeyChange{}
+	for i := range keys {
+		change := KeyChange{
+			Fingerprint:    keys[i].Fingerprint,
+			PublicKey:      keys[i].PublicKey,
+			VerifiedEmails: []string{},
+			Generation:     keys[i].Generation,
+			UpdatedAt:      keys[i].UpdatedAt,
+		}
+		for _, verified := range keys[i].VerifiedEmails {
+			change.VerifiedEmails = append(change.VerifiedEmails, verified.Email)
+		}
+		if keys[i].DeletedAt.Valid {
+			change.DeletedAt = &keys[i].DeletedAt.Time
+		}
+		changes = append(changes, change)
+		cursor = changeCursor(&keys[i])
+	}
+	return changes, cursor, more, nil
+}
+
+// deletionWins decides between a tombstone and a live copy of a key by their generations,
+// which every instance counts the same way. Deletions are final while deleted keys are
+// refused, otherwise a restore only wins over the deletions of earlier generations, so
+// every instance settles on the same outcome whatever their clocks say.
+func deletionWins(deleted, live int) bool {
+	return config.Current.GPG.RefuseDeletedKeys || deleted >= live
+}
+
+// ApplyKeyChange merges a key change pulled from a peer, using the same merge as uploads.
+// Verified addresses are merged too, so an address verified on any instance is published
+// on all of them. Deletions are applied before the UID policy, which could otherwise reject
+// the key and keep the deletion from ever arriving.
+func ApplyKeyChange(db *gorm.DB, change *KeyChange) (KeyImportStatus, string, error) {
+	parsedKeys, err := ParsePubKeys(change.PublicKey)
+	if err != nil {
+		return KeyRejected, err.Error(), nil
+	}
+	if len(parsedKeys) != 1 || parsedKeys[0].entity == nil {
+		return KeyRejected, "expected a single key", nil
+	}
+	parsed := &parsedKeys[0]
+	if parsed.Fingerprint != strings.ToLower(change.Fingerprint) {
+		return KeyRejected, "fingerprint does not match the key", nil
+	}
+
+	existing := GPGPubKeyStore{}
+	err = db.Unscoped().Where("key_id = ?", parsed.Key.KeyID).First(&existing).Error
+	found := err == nil
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return "", "", err
+	}
+
+	if change.DeletedAt != nil {
+		return applyKeyDeletion(db, parsed, &existing, found, change)
+	}
+
+	if _, err := applyUIDPolicy(parsed); err != nil {
+		return "", "", err
+	}
+	if parsed.Err != nil {
+		return KeyRejected, parsed.Err.Error(), nil
+	}
+
+	restored := false
+	if found && existing.DeletedAt.Valid {
+		if deletionWins(existing.Generation, change.Generation) {
+			return KeyUnchanged, "", nil
+		}
+		// Restore the key first so it gets merged like any stored key.
+		if err := db.Unscoped().Model(&existing).Update("deleted_at", nil).Error; err != nil {
+			return "", "", err
+		}
+		restored = true
+	}
+
+	status, reason, err := addPubKey(db, parsed)
+	if err != nil || status == KeyRejected {
+		return status, reason, err
+	}
+	if err := raiseGeneration(db, parsed.Key.KeyID, change.Generation); err != nil {
+		return "", "", err
+	}
+
+	verified, err := addVerifiedEmails(db, parsed.Key.KeyID, change.VerifiedEmails)
+	if err != nil {
+		return "", "", err
+	}
+	if status == KeyUnchanged && (verified || restored) {
+		status = KeyUpdated
+	}
+	return status, "", nil
+}
+
+func applyKeyDeletion(db *gorm.DB, parsed *ParsedPubKey, existing *GPGPubKeyStore, found bool, change *KeyChange) (KeyImportStatus, string, error) {
+	if parsed.Err != nil {
+		return KeyRejected, parsed.Err.Error(), nil
+	}
+	if !found {
+		// Keep the tombstone, so the key stays deleted if it is uploaded here later.
+		parsed.Key.DeletedAt = gorm.DeletedAt{Time: *change.DeletedAt, Valid: true}
+		parsed.Key.Generation = change.Generation
+		return KeyDeleted, "", db.Create(&parsed.Key).Error
+	}
+	if existing.DeletedAt.Valid || !deletionWins(change.Generation, existing.Generation) {
+		return KeyUnchanged, "", raiseGeneration(db, existing.KeyID, change.Generation)
+	}
+	if err := DeletePubKey(db, existing.Fingerprint); err != nil {
+		return "", "", err
+	}
+	return KeyDeleted, "", raiseGeneration(db, existing.KeyID, change.Generation)
+}
+
+// raiseGeneration brings the generation of a stored key up to one seen on a peer, so a later
+// deletion here outranks the restores the peer has already made.
+func raiseGeneration(db *gorm.DB, keyID string, generation int) error {
+	return db.Unscoped().Model(&GPGPubKeyStore{}).Where("key_id = ? AND generation < ?", keyID, generation).
+		UpdateColumn("generation", generation).Error
+}
+
+// addVerifiedEmails records the addresses as verified on the key, skipping the ones that
+// are not on it. It reports whether any address was new.
+func addVerifiedEmails(db *gorm.DB, keyID string, emails []string) (bool, error) {
+	users := []string{}
+	if err := db.Model(&GPGUsers{}).Where("key_id = ?", keyID).Pluck("email", &users).Error; err != nil {
+		return false, err
+	}
+
+	added := false
+	for _, email := range emails {
+		email = strings.ToLower(email)
+		if !slices.Contains(users, email) {
+			continue
+		}
+		verified := GPGVerifiedEmail{KeyID: keyID, Email: email}
+		result := db.Where(verified).Attrs(GPGVerifiedEmail{VerifiedAt: time.Now()}).FirstOrCreate(&verified)
+		if result.Error != nil {
+			return false, result.Error
+		}
+		added = added || result.RowsAffected > 0
+	}
+	if !added {
+		return false, nil
+	}
+	return true, touchPubKey(db, keyID)
+}
+
+// GetReplicationState returns the pull state of a peer, empty if it was never pulled.
+func GetReplicationState(db *gorm.DB, peer string) (*ReplicationState, error) {
+	state := &ReplicationState{Peer: peer}
+	if err := db.Where(state).FirstOrInit(state).Error; err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func SaveReplicationState(db *gorm.DB, state *ReplicationState) error {
+	return db.Save(state).Error
+}
@@ -0,0 +1,119 @@
+package models
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/hibare/DomainHQ/internal/constants"
+	"gorm.io/gorm"
+)
+
+// GPGUpstreamKey caches keys fetched from an upstream keyserver. They are kept apart from
+// the keys stored here since nobody has verified them. An entry without a key records that
+// no keyserver had one.
+type GPGUpstreamKey struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Search    string    `gorm:"uniqueIndex" json:"search"`
+	Source    string    `json:"source"`
+	PublicKey string    `json:"public_key"`
+	FetchedAt time.Time `json:"fetched_at"`
+	ExpiresAt time.Time `gorm:"index" json:"expires_at"`
+}
+
+func (GPGUpstreamKey) TableName() string {
+	return "gpg_upstream_keys"
+}
+
+// Found reports whether the entry holds keys rather than a remembered miss.
+func (k *GPGUpstreamKey) Found() bool {
+	return k.PublicKey != ""
+}
+
+// Data returns the cached keys as binary OpenPGP packets.
+func (k *GPGUpstreamKey) Data() ([]byte, error) {
+	return dearmorKey(k.PublicKey)
+}
+
+func upstreamSearch(search string) string {
+	return strings.ToLower(strings.TrimSpace(search))
+}
+
+// upstreamMatch reports whether a key answers a search: by fingerprint or key ID of the key
+// or one of its subkeys, or by the email of one of its user IDs.
+func upstreamMatch(entity *openpgp.Entity, search string) bool {
+	search = upstreamSearch(search)
+	if strings.Contains(search, "@") {
+		for _, identity := range entity.Identities {
+			if strings.ToLower(identity.UserId.Email) == search {
+				return true
+			}
+		}
+		return false
+	}
+
+	id := strings.TrimPrefix(search, constants.GPGFingerprintPrefix)
+	keys := []*packet.PublicKey{entity.PrimaryKey}
+	for _, subkey := range entity.Subkeys {
+		keys = append(keys, subkey.PublicKey)
+	}
+	for _, key := range keys {
+		if id == hex.EncodeToString(key.Fingerprint) || id == strings.ToLower(key.KeyIdString()) || id == strings.ToLower(key.KeyIdShortString()) {
+			return true
+		}
+	}
+	return false
+}
+
+// SanitizeUpstreamKeys keeps only the public keys of an upstream response that answer the
+// search, dropping revocation certificates, private keys, unrelated keys and anything
+// unreadable, and re-armors them.
+func SanitizeUpstreamKeys(keyText, search string) (string, error) {
+	parsedKeys, err := ParsePubKeys(keyText)
+	if err != nil {
+		return "", err
+	}
+
+	data := &bytes.Buffer{}
+	for _, parsed := range parsedKeys {
+		if parsed.Err != nil || parsed.entity == nil || !upstreamMatch(parsed.entity, search) {
+			continue
+		}
+		data.Write(parsed.data)
+	}
+	if data.Len() == 0 {
+		return "", fmt.Errorf("no usable key found")
+	}
+	return armorKey(data.Bytes())
+}
+
+// GetUpstreamKey returns the cached upstream keys for a search, unless they have expired.
+func GetUpstreamKey(db *gorm.DB, search string, now time.Time) (*GPGUpstreamKey, error) {
+	key := &GPGUpstreamKey{}
+	err := db.Where("search = ? AND expires_at > ?", upstreamSearch(search), now).First(key).Error
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// SaveUpstreamKey caches the keys fetched for a search, replacing any earlier result.
+func SaveUpstreamKey(db *gorm.DB, key *GPGUpstreamKey) error {
+	key.Search = upstreamSearch(key.Search)
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("search = ?", key.Search).Delete(&GPGUpstreamKey{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(key).Error
+	})
+}
+
+// PurgeUpstreamKeys removes the expired upstream cache entries.
+func PurgeUpstreamKeys(db *gorm.DB, now time.Time) (int64, error) {
+	result := db.Where("expires_at <= ?", now).Delete(&GPGUpstreamKey{})
+	return result.RowsAffected, result.Error
+}
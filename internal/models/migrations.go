@@ -0,0 +1,218 @@
+package models
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SchemaMigration records a data migration that has been applied.
+type SchemaMigration struct {
+	ID        string    `gorm:"primaryKey"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+type migration struct {
+	id      string
+	migrate func(tx *gorm.DB) error
+}
+
+// migrations run once each, in order, before the schema is auto migrated. Append new
+// migrations to the end and never rename or reorder applied ones.
+var migrations = []migration{
+	{id: "0001_backfill_gpg_subkeys", migrate: backfillSubkeys},
+	{id: "0002_gpg_users_key_id", migrate: migrateGPGUsersKeyID},
+	{id: "0003_gpg_key_algorithms", migrate: migrateGPGKeyAlgorithms},
+	{id: "0004_gpg_users_wkd_hash", migrate: migrateGPGUsersWKDHash},
+}
+
+// runMigrations applies the pending migrations, each in its own transaction.
+func runMigrations(db *gorm.DB) error {
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return err
+	}
+
+	applied := []string{}
+	if err := db.Model(&SchemaMigration{}).Pluck("id", &applied).Error; err != nil {
+		return err
+	}
+	done := map[string]bool{}
+	for _, id := range applied {
+		done[id] = true
+	}
+
+	for _, m := range migrations {
+		if done[m.id] {
+			continue
+		}
+		slog.Info("Applying migration", "id", m.id)
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.migrate(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{ID: m.id, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migration %s failed: %w", m.id, err)
+		}
+	}
+	return nil
+}
+
+// storedKeys returns every stored key, including deleted ones, or nothing on a new database.
+func storedKeys(tx *gorm.DB) ([]GPGPubKeyStore, error) {
+	keys := []GPGPubKeyStore{}
+	if !tx.Migrator().HasTable(&GPGPubKeyStore{}) {
+		return keys, nil
+	}
+	err := tx.Unscoped().Select("key_id", "fingerprint", "public_key").Find(&keys).Error
+	return keys, err
+}
+
+// backfillSubkeys records the subkeys of keys stored before subkeys had a table of their own.
+// Keys that already have subkeys recorded are left alone.
+func backfillSubkeys(tx *gorm.DB) error {
+	keys, err := storedKeys(tx)
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+	if !tx.Migrator().HasTable(&GPGSubkey{}) {
+		if err := tx.Migrator().CreateTable(&GPGSubkey{}); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	for i := range keys {
+		var count int64
+		if err := tx.Model(&GPGSubkey{}).Where("parent_key_id = ?", keys[i].KeyID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+
+		entity, err := keys[i].Entity()
+		if err != nil {
+			return fmt.Errorf("failed to parse key %s: %w", keys[i].Fingerprint, err)
+		}
+		for j := range entity.Subkeys {
+			subkey := newSubkey(&entity.Subkeys[j], now)
+			subkey.ParentKeyID = keys[i].KeyID
+			if err := tx.Create(&subkey).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// migrateGPGUsersKeyID replaces the user ID table, which used the key ID as its own primary
+// key and so held a single user ID per key, and rebuilds the rows from the stored keys.
+func migrateGPGUsersKeyID(tx *gorm.DB) error {
+	migrator := tx.Migrator()
+	if !migrator.HasTable(&GPGUsers{}) || migrator.HasColumn(&GPGUsers{}, "key_id") {
+		return nil
+	}
+
+	if err := migrator.DropTable(&GPGUsers{}); err != nil {
+		return err
+	}
+	if err := migrator.CreateTable(&GPGUsers{}); err != nil {
+		return err
+	}
+
+	keys, err := storedKeys(tx)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for i := range keys {
+		entity, err := keys[i].Entity()
+		if err != nil {
+			return fmt.Errorf("failed to parse key %s: %w", keys[i].Fingerprint, err)
+		}
+		users := newUsers(entity, now)
+		for j := range users {
+			users[j].KeyID = keys[i].KeyID
+		}
+		if len(users) > 0 {
+			if err := tx.Create(&users).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// migrateGPGKeyAlgorithms replaces the algorithm stored as a single rune on keys, and as a
+// number on subkeys, with its name, and records bit lengths and curves. Subkeys are rebuilt
+// from the stored keys.
+func migrateGPGKeyAlgorithms(tx *gorm.DB) error {
+	keys, err := storedKeys(tx)
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+
+	migrator := tx.Migrator()
+	for _, column := range []string{"BitLength", "Curve", "CurveOID"} {
+		if !migrator.HasColumn(&GPGPubKeyStore{}, column) {
+			if err := migrator.AddColumn(&GPGPubKeyStore{}, column); err != nil {
+				return err
+			}
+		}
+	}
+	if migrator.HasTable(&GPGSubkey{}) {
+		if err := migrator.DropTable(&GPGSubkey{}); err != nil {
+			return err
+		}
+	}
+
+	for i := range keys {
+		entity, err := keys[i].Entity()
+		if err != nil {
+			return fmt.Errorf("failed to parse key %s: %w", keys[i].Fingerprint, err)
+		}
+		algorithm := NewKeyAlgorithm(entity.PrimaryKey)
+		err = tx.Unscoped().Model(&GPGPubKeyStore{}).Where("key_id = ?", keys[i].KeyID).UpdateColumns(map[string]any{
+			"algorithm":  algorithm.Name,
+			"bit_length": algorithm.BitLength,
+			"curve":      algorithm.Curve,
+			"curve_oid":  algorithm.CurveOID,
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return backfillSubkeys(tx)
+}
+
+// migrateGPGUsersWKDHash records the WKD hash of the user IDs stored before it had a column.
+func migrateGPGUsersWKDHash(tx *gorm.DB) error {
+	migrator := tx.Migrator()
+	if !migrator.HasTable(&GPGUsers{}) {
+		return nil
+	}
+	if !migrator.HasColumn(&GPGUsers{}, "WKDHash") {
+		if err := migrator.AddColumn(&GPGUsers{}, "WKDHash"); err != nil {
+			return err
+		}
+	}
+
+	users := []GPGUsers{}
+	if err := tx.Select("id", "email").Where("email <> ''").Find(&users).Error; err != nil {
+		return err
+	}
+	for _, user := range users {
+		if err := tx.Model(&user).UpdateColumn("wkd_hash", emailWKDHash(user.Email)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type WebFingerAlias struct {
+	ID        uint   `gorm:"primaryKey" json:"id"`
+	AccountID uint   `gorm:"index;not null" json:"-"`
+	URI       string `gorm:"uniqueIndex;not null" json:"uri"`
+}
+
+func (WebFingerAlias) TableName() string {
+	return "webfinger_aliases"
+}
+
+type WebFingerLink struct {
+	ID         uint             `gorm:"primaryKey" json:"id"`
+	AccountID  uint             `gorm:"index;not null" json:"-"`
+	Rel        string           `gorm:"not null" json:"rel"`
+	Type       string           `json:"type"`
+	Href       string           `json:"href"`
+	Titles     JSONMap[string]  `json:"titles"`
+	Properties JSONMap[*string] `json:"properties"`
+	Template   string           `json:"template"`
+}
+
+func (WebFingerLink) TableName() string {
+	return "webfinger_links"
+}
+
+type WebFingerAccount struct {
+	ID         uint             `gorm:"primaryKey" json:"id"`
+	Subject    string           `gorm:"uniqueIndex;not null" json:"subject"`
+	Aliases    []WebFingerAlias `gorm:"foreignKey:AccountID;constraint:OnDelete:CASCADE" json:"aliases"`
+	Properties JSONMap[*string] `json:"properties"`
+	Links      []WebFingerLink  `gorm:"foreignKey:AccountID;constraint:OnDelete:CASCADE" json:"links"`
+	CreatedAt  time.Time        `json:"created_at"`
+	UpdatedAt  time.Time        `json:"updated_at"`
+}
+
+func (WebFingerAccount) TableName() string {
+	return "webfinger_accounts"
+}
+
+// AliasURIs returns the account aliases as plain URIs.
+func (a *WebFingerAccount) AliasURIs() []string {
+	uris := []string{}
+	for _, alias := range a.Aliases {
+		uris = append(uris, alias.URI)
+	}
+	return uris
+}
+
+func preloadWebFingerAccount(db *gorm.DB) *gorm.DB {
+	orderByID := func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}
+	return db.Preload("Aliases", orderByID).Preload("Links", orderByID)
+}
+
+func CreateWebFingerAccount(db *gorm.DB, account *WebFingerAccount) error {
+	account.Subject = strings.ToLower(account.Subject)
+	return db.Create(account).Error
+}
+
+func GetWebFingerAccount(db *gorm.DB, subject string) (*WebFingerAccount, error) {
+	account := WebFingerAccount{}
+	err := preloadWebFingerAccount(db).
+		Where("subject = ?", strings.ToLower(subject)).
+		First(&account).Error
+	if err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+func GetWebFingerAccountByAlias(db *gorm.DB, uri string) (*WebFingerAccount, error) {
+	alias := WebFingerAlias{}
+	if err := db.Where("uri = ?", uri).First(&alias).Error; err != nil {
+		return nil, err
+	}
+
+	account := WebFingerAccount{}
+	err := preloadWebFingerAccount(db).First(&account, alias.AccountID).Error
+	if err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+func GetWebFingerAccountByID(db *gorm.DB, id uint) (*WebFingerAccount, error) {
+	account := WebFingerAccount{}
+	if err := preloadWebFingerAccount(db).First(&account, id).Error; err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// ListWebFingerAccounts lists all accounts, optionally limited to a single domain.
+func ListWebFingerAccounts(db *gorm.DB, domain string) ([]WebFingerAccount, error) {
+	accounts := []WebFingerAccount{}
+	query := preloadWebFingerAccount(db).Order("subject")
+	if domain != "" {
+		query = query.Where(`subject LIKE ? ESCAPE '\'`, "%@"+escapeLike(strings.ToLower(domain)))
+	}
+	if err := query.Find(&accounts).Error; err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
+// UpdateWebFingerAccount replaces the subject, properties, aliases and links of an account.
+func UpdateWebFingerAccount(db *gorm.DB, account *WebFingerAccount) error {
+	account.Subject = strings.ToLower(account.Subject)
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&WebFingerAccount{}).Where("id = ?", account.ID).Updates(map[string]any{
+			"subject":    account.Subject,
+			"properties": account.Properties,
+			"updated_at": time.Now(),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		if err := tx.Where("account_id = ?", account.ID).Delete(&WebFingerAlias{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("account_id = ?", account.ID).Delete(&WebFingerLink{}).Error; err != nil {
+			return err
+		}
+
+		for i := range account.Aliases {
+			account.Aliases[i].ID = 0
+			account.Aliases[i].AccountID = account.ID
+		}
+		if len(account.Aliases) > 0 {
+			if err := tx.Create(&account.Aliases).Error; err != nil {
+				return err
+			}
+		}
+
+		for i := range account.Links {
+			account.Links[i].ID = 0
+			account.Links[i].AccountID = account.ID
+		}
+		if len(account.Links) > 0 {
+			if err := tx.Create(&account.Links).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func DeleteWebFingerAccount(db *gorm.DB, id uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("account_id = ?", id).Delete(&WebFingerAlias{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("account_id = ?", id).Delete(&WebFingerLink{}).Error; err != nil {
+			return err
+		}
+
+		result := tx.Delete(&WebFingerAccount{}, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+// touchWebFingerAccount bumps the account modification time so cached responses are invalidated.
+func touchWebFingerAccount(db *gorm.DB, id uint) error {
+	result := db.Model(&WebFingerAccount{}).Where("id = ?", id).Update("updated_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func AddWebFingerAlias(db *gorm.DB, alias *WebFingerAlias) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := touchWebFingerAccount(tx, alias.AccountID); err != nil {
+			return err
+		}
+		return tx.Create(alias).Error
+	})
+}
+
+func DeleteWebFingerAlias(db *gorm.DB, accountID, aliasID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("account_id = ?", accountID).Delete(&WebFingerAlias{}, aliasID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return touchWebFingerAccount(tx, accountID)
+	})
+}
+
+func AddWebFingerLink(db *gorm.DB, link *WebFingerLink) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := touchWebFingerAccount(tx, link.AccountID); err != nil {
+			return err
+		}
+		return tx.Create(link).Error
+	})
+}
+
+func UpdateWebFingerLink(db *gorm.DB, link *WebFingerLink) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(link).Where("account_id = ?", link.AccountID).
+			Select("rel", "type", "href", "titles", "properties", "template").
+			Updates(link)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return touchWebFingerAccount(tx, link.AccountID)
+	})
+}
+
+func DeleteWebFingerLink(db *gorm.DB, accountID, linkID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("account_id = ?", accountID).Delete(&WebFingerLink{}, linkID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return touchWebFingerAccount(tx, accountID)
+	})
+}
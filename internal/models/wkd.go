@@ -0,0 +1,48 @@
+package models
+
+import (
+	"crypto/sha1"
+	"strings"
+)
+
+// zbase32Alphabet is the z-base-32 alphabet used for WKD hashes.
+const zbase32Alphabet = "ybndrfg8ejkmcpqxot1uwisza345h769"
+
+func zbase32Encode(data []byte) string {
+	var b strings.Builder
+	buffer, bits := 0, 0
+	for _, c := range data {
+		buffer = buffer<<8 | int(c)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			b.WriteByte(zbase32Alphabet[(buffer>>bits)&0x1f])
+		}
+	}
+	if bits > 0 {
+		b.WriteByte(zbase32Alphabet[(buffer<<(5-bits))&0x1f])
+	}
+	return b.String()
+}
+
+// WKDHash hashes the local part of an address as described in
+// draft-koch-openpgp-webkey-service section 3.1. Only ASCII letters are lowercased.
+func WKDHash(local string) string {
+	lower := []byte(local)
+	for i, c := range lower {
+		if 'A' <= c && c <= 'Z' {
+			lower[i] = c + 'a' - 'A'
+		}
+	}
+	sum := sha1.Sum(lower)
+	return zbase32Encode(sum[:])
+}
+
+// emailWKDHash returns the WKD hash of the local part of an address, or "" without one.
+func emailWKDHash(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at <= 0 {
+		return ""
+	}
+	return WKDHash(email[:at])
+}
@@ -0,0 +1,200 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/hibare/DomainHQ/internal/config"
+	"github.com/hibare/DomainHQ/internal/models"
+	commonMiddleware "github.com/hibare/GoCommon/v2/pkg/http/middleware"
+	"gorm.io/gorm"
+)
+
+const (
+	// FeedPath is where every instance serves its change feed.
+	FeedPath = "/api/v1/replication/changes"
+
+	// BatchSize is the number of changes pulled per request.
+	BatchSize = 100
+
+	// maxBatchesPerSync bounds a single sync, the rest is pulled on the next run.
+	maxBatchesPerSync = 50
+
+	// maxFeedSize limits how much of a feed response is read.
+	maxFeedSize = 64 << 20
+)
+
+// ChangeFeed is a page of the change feed. NextCursor is where the next page starts, More
+// reports whether it has changes already.
+type ChangeFeed struct {
+	Changes    []models.KeyChange `json:"changes"`
+	NextCursor string             `json:"next_cursor"`
+	More       bool               `json:"more"`
+}
+
+// PeerStatus is the sync state of a peer. Lag is the time since the peer's feed was last
+// pulled to the end, nil if that never happened.
+type PeerStatus struct {
+	Name  string                   `json:"name"`
+	URL   string                   `json:"url"`
+	State *models.ReplicationState `json:"state"`
+	Lag   *float64                 `json:"lag_seconds"`
+}
+
+// Peer pulls the change feed of another instance.
+type Peer struct {
+	Name   string
+	URL    string
+	token  string
+	client *http.Client
+}
+
+func NewPeer(cfg config.ReplicationPeer, timeout time.Duration) *Peer {
+	return &Peer{
+		Name:   cfg.Name,
+		URL:    cfg.URL,
+		token:  cfg.Token,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// Changes fetches the changes after the cursor.
+func (p *Peer) Changes(ctx context.Context, cursor string, limit int) (*ChangeFeed, error) {
+	query := url.Values{"cursor": {cursor}, "limit": {strconv.Itoa(limit)}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL+FeedPath+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set(commonMiddleware.AuthHeaderName, p.token)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected peer response: %s", resp.Status)
+	}
+
+	feed := &ChangeFeed{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxFeedSize)).Decode(feed); err != nil {
+		return nil, err
+	}
+	return feed, nil
+}
+
+// Syncer pulls the changes of every configured peer into the local store.
+type Syncer struct {
+	db    *gorm.DB
+	peers []*Peer
+}
+
+func New(cfg config.ReplicationConfig, db *gorm.DB) *Syncer {
+	peers := []*Peer{}
+	for _, peer := range cfg.Peers {
+		peers = append(peers, NewPeer(peer, cfg.Timeout))
+	}
+	return NewSyncer(db, peers...)
+}
+
+func NewSyncer(db *gorm.DB, peers ...*Peer) *Syncer {
+	return &Syncer{db: db, peers: peers}
+}
+
+// Sync pulls every peer in turn. A failing peer does not hold up the others, its error is
+// recorded in its state.
+func (s *Syncer) Sync(ctx context.Context) {
+	for _, peer := range s.peers {
+		if err := s.SyncPeer(ctx, peer); err != nil {
+			slog.Error("Error replicating from peer", "peer", peer.Name, "error", err)
+		}
+	}
+}
+
+// SyncPeer pulls the changes of a peer. Each batch is applied together with the new cursor,
+// so a failed sync picks up where the last applied batch ended.
+func (s *Syncer) SyncPeer(ctx context.Context, peer *Peer) error {
+	state, err := models.GetReplicationState(s.db, peer.Name)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	state.LastAttemptAt = &now
+	err = s.pull(ctx, peer, state)
+	state.LastError = ""
+	if err != nil {
+		state.LastError = err.Error()
+	}
+
+	if saveErr := models.SaveReplicationState(s.db, state); saveErr != nil {
+		return saveErr
+	}
+	return err
+}
+
+func (s *Syncer) pull(ctx context.Context, peer *Peer, state *models.ReplicationState) error {
+	for range maxBatchesPerSync {
+		feed, err := peer.Changes(ctx, state.Cursor, BatchSize)
+		if err != nil {
+			return err
+		}
+
+		err = s.db.Transaction(func(tx *gorm.DB) error {
+			for i := range feed.Changes {
+				status, reason, err := models.ApplyKeyChange(tx, &feed.Changes[i])
+				if err != nil {
+					return err
+				}
+				if status == models.KeyRejected {
+					slog.Warn("Skipping replicated key", "peer", peer.Name, "fingerprint", feed.Changes[i].Fingerprint, "reason", reason)
+				}
+			}
+
+			next := *state
+			next.Cursor = feed.NextCursor
+			next.Applied += int64(len(feed.Changes))
+			return models.SaveReplicationState(tx, &next)
+		})
+		if err != nil {
+			return err
+		}
+		state.Cursor = feed.NextCursor
+		state.Applied += int64(len(feed.Changes))
+
+		if !feed.More {
+			now := time.Now()
+			state.CaughtUpAt = &now
+			return nil
+		}
+	}
+	return nil
+}
+
+// Status reports the sync state of every configured peer.
+func (s *Syncer) Status(now time.Time) ([]PeerStatus, error) {
+	statuses := []PeerStatus{}
+	for _, peer := range s.peers {
+		state, err := models.GetReplicationState(s.db, peer.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		status := PeerStatus{Name: peer.Name, URL: peer.URL, State: state}
+		if state.CaughtUpAt != nil {
+			lag := now.Sub(*state.CaughtUpAt).Seconds()
+			status.Lag = &lag
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
@@ -0,0 +1,46 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hibare/DomainHQ/internal/config"
+	"github.com/hibare/DomainHQ/internal/models"
+	commonMiddleware "github.com/hibare/GoCommon/v2/pkg/http/middleware"
+	"github.com/stretchr/testify/assert"
+)
+
+const testToken = "test-token"
+
+func TestPeerChanges(t *testing.T) {
+	peerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(commonMiddleware.AuthHeaderName) != testToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != FeedPath || r.URL.Query().Get("limit") != "10" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		feed := ChangeFeed{NextCursor: r.URL.Query().Get("cursor") + "-next", More: true}
+		feed.Changes = []models.KeyChange{{Fingerprint: "abc"}}
+		json.NewEncoder(w).Encode(feed)
+	}))
+	defer peerServer.Close()
+
+	peer := NewPeer(config.ReplicationPeer{Name: "eu", URL: peerServer.URL, Token: testToken}, time.Second)
+	feed, err := peer.Changes(context.Background(), "cursor", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, "cursor-next", feed.NextCursor)
+	assert.True(t, feed.More)
+	assert.Equal(t, []models.KeyChange{{Fingerprint: "abc"}}, feed.Changes)
+
+	peer = NewPeer(config.ReplicationPeer{Name: "eu", URL: peerServer.URL, Token: "wrong"}, time.Second)
+	_, err = peer.Changes(context.Background(), "", 10)
+	assert.Error(t, err)
+}
@@ -0,0 +1,88 @@
+package resolver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// HTTPResolver resolves accounts by querying the identity provider over HTTP.
+//
+// The URL is a template where {account}, {user} and {domain} are replaced with the
+// query escaped account, local part and domain, e.g.
+//
+//	https://idp.example.com/scim/v2/Users?filter=userName%20eq%20%22{account}%22
+//
+// A 404 means the account does not exist. A 2xx response means it does, unless the
+// body is a SCIM list response with "totalResults": 0.
+type HTTPResolver struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+type scimListResponse struct {
+	TotalResults *int `json:"totalResults"`
+}
+
+func NewHTTPResolver(urlTemplate, token string, timeout time.Duration) *HTTPResolver {
+	return &HTTPResolver{
+		url:    urlTemplate,
+		token:  token,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+func (h *HTTPResolver) requestURL(subject string) string {
+	account := strings.TrimPrefix(normalizeSubject(subject), "acct:")
+	user, domain := account, ""
+	if at := strings.LastIndex(account, "@"); at >= 0 {
+		user, domain = account[:at], account[at+1:]
+	}
+
+	return strings.NewReplacer(
+		"{account}", url.QueryEscape(account),
+		"{user}", url.QueryEscape(user),
+		"{domain}", url.QueryEscape(domain),
+	).Replace(h.url)
+}
+
+func (h *HTTPResolver) Exists(ctx context.Context, subject string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.requestURL(subject), nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "application/scim+json, application/json")
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return false, nil
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return false, fmt.Errorf("unexpected resolver response: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return false, err
+	}
+
+	list := scimListResponse{}
+	if json.Unmarshal(body, &list) == nil && list.TotalResults != nil {
+		return *list.TotalResults > 0, nil
+	}
+	return true, nil
+}
@@ -0,0 +1,78 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hibare/DomainHQ/internal/config"
+	"github.com/hibare/DomainHQ/internal/constants"
+	"github.com/hibare/DomainHQ/internal/models"
+	"gorm.io/gorm"
+)
+
+// AccountResolver checks whether a WebFinger subject (acct:user@domain) is a known account.
+type AccountResolver interface {
+	Exists(ctx context.Context, subject string) (bool, error)
+}
+
+func New(cfg config.WebFingerResolverConfig, db *gorm.DB) (AccountResolver, error) {
+	switch cfg.Backend {
+	case constants.ResolverBackendStatic:
+		return NewStaticResolver(cfg.Accounts), nil
+	case constants.ResolverBackendDatabase:
+		return NewDatabaseResolver(db), nil
+	case constants.ResolverBackendHTTP:
+		return NewHTTPResolver(cfg.URL, cfg.Token, cfg.Timeout), nil
+	default:
+		return nil, fmt.Errorf("unknown resolver backend %q", cfg.Backend)
+	}
+}
+
+// normalizeSubject lower-cases the subject and makes sure it carries the acct: scheme.
+func normalizeSubject(subject string) string {
+	subject = strings.ToLower(strings.TrimSpace(subject))
+	if !strings.HasPrefix(subject, "acct:") {
+		subject = "acct:" + subject
+	}
+	return subject
+}
+
+// StaticResolver resolves accounts against a fixed list.
+type StaticResolver struct {
+	accounts map[string]bool
+}
+
+func NewStaticResolver(accounts []string) *StaticResolver {
+	s := &StaticResolver{accounts: map[string]bool{}}
+	for _, account := range accounts {
+		if strings.TrimSpace(account) != "" {
+			s.accounts[normalizeSubject(account)] = true
+		}
+	}
+	return s
+}
+
+func (s *StaticResolver) Exists(_ context.Context, subject string) (bool, error) {
+	return s.accounts[normalizeSubject(subject)], nil
+}
+
+// DatabaseResolver resolves accounts against the stored WebFinger accounts.
+type DatabaseResolver struct {
+	db *gorm.DB
+}
+
+func NewDatabaseResolver(db *gorm.DB) *DatabaseResolver {
+	return &DatabaseResolver{db: db}
+}
+
+func (d *DatabaseResolver) Exists(ctx context.Context, subject string) (bool, error) {
+	_, err := models.GetWebFingerAccount(d.db.WithContext(ctx), normalizeSubject(subject))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
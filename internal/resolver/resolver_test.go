@@ -0,0 +1,90 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testToken = "test-token"
+
+func TestStaticResolver(t *testing.T) {
+	accounts := NewStaticResolver([]string{"john@example.com", "acct:Jane@Example.com", ""})
+
+	testCases := []struct {
+		Name    string
+		Subject string
+		Exists  bool
+	}{
+		{Name: "Plain account", Subject: "acct:john@example.com", Exists: true},
+		{Name: "Mixed case", Subject: "acct:JANE@example.com", Exists: true},
+		{Name: "Unknown account", Subject: "acct:bob@example.com", Exists: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			exists, err := accounts.Exists(context.Background(), tc.Subject)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.Exists, exists)
+		})
+	}
+}
+
+func TestHTTPResolver(t *testing.T) {
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/users/john@example.com":
+			fmt.Fprint(w, `{"sub": "john"}`)
+		case "/users/broken@example.com":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/scim/v2/Users":
+			if r.URL.Query().Get("filter") == `userName eq "john@example.com"` {
+				fmt.Fprint(w, `{"totalResults": 1, "Resources": [{"userName": "john@example.com"}]}`)
+				return
+			}
+			fmt.Fprint(w, `{"totalResults": 0, "Resources": []}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer idp.Close()
+
+	testCases := []struct {
+		Name      string
+		URL       string
+		Token     string
+		Subject   string
+		Exists    bool
+		ExpectErr bool
+	}{
+		{Name: "Existing user", URL: idp.URL + "/users/{user}@{domain}", Token: testToken, Subject: "acct:john@example.com", Exists: true},
+		{Name: "Unknown user", URL: idp.URL + "/users/{user}@{domain}", Token: testToken, Subject: "acct:bob@example.com", Exists: false},
+		{Name: "SCIM existing user", URL: idp.URL + `/scim/v2/Users?filter=userName%20eq%20%22{account}%22`, Token: testToken, Subject: "acct:john@example.com", Exists: true},
+		{Name: "SCIM unknown user", URL: idp.URL + `/scim/v2/Users?filter=userName%20eq%20%22{account}%22`, Token: testToken, Subject: "acct:bob@example.com", Exists: false},
+		{Name: "IdP error", URL: idp.URL + "/users/{user}@{domain}", Token: testToken, Subject: "acct:broken@example.com", ExpectErr: true},
+		{Name: "Unauthorized", URL: idp.URL + "/users/{user}@{domain}", Token: "", Subject: "acct:john@example.com", ExpectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			accounts := NewHTTPResolver(tc.URL, tc.Token, time.Second)
+			exists, err := accounts.Exists(context.Background(), tc.Subject)
+			if tc.ExpectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.Exists, exists)
+		})
+	}
+}
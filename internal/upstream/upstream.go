@@ -0,0 +1,197 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/hibare/DomainHQ/internal/config"
+	"github.com/hibare/DomainHQ/internal/constants"
+)
+
+// ErrNotFound means no upstream keyserver has a key for the search.
+var ErrNotFound = errors.New("key not found upstream")
+
+// maxResponseSize limits how much of an upstream response is read.
+const maxResponseSize = 10 << 20
+
+var (
+	fingerprintPattern = regexp.MustCompile(`^[0-9a-f]{40}$|^[0-9a-f]{64}$`)
+	keyIDPattern       = regexp.MustCompile(`^[0-9a-f]{16}$`)
+)
+
+// Keyserver fetches armored keys from a single upstream server.
+type Keyserver interface {
+	// Fetch returns the armored keys matching the search, or ErrNotFound.
+	Fetch(ctx context.Context, search string) (string, error)
+	URL() string
+}
+
+// Client asks the upstream keyservers in order until one of them has the key.
+type Client struct {
+	servers []Keyserver
+}
+
+func New(cfg config.UpstreamConfig) (*Client, error) {
+	client := &http.Client{Timeout: cfg.Timeout}
+	servers := []Keyserver{}
+	for _, u := range cfg.HKPServers {
+		server, err := NewHKPServer(u, client)
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, server)
+	}
+	for _, u := range cfg.VKSServers {
+		server, err := NewVKSServer(u, client)
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, server)
+	}
+	return NewClient(servers...), nil
+}
+
+func NewClient(servers ...Keyserver) *Client {
+	return &Client{servers: servers}
+}
+
+// Fetch returns the armored keys from the first server that has them, along with that
+// server's URL. Failing servers are skipped, their error is only returned when no other
+// server had the key either.
+func (c *Client) Fetch(ctx context.Context, search string) (string, string, error) {
+	var lastErr error
+	for _, server := range c.servers {
+		keyText, err := server.Fetch(ctx, search)
+		switch {
+		case err == nil:
+			return keyText, server.URL(), nil
+		case errors.Is(err, ErrNotFound):
+		default:
+			slog.Warn("Upstream keyserver failed", "server", server.URL(), "error", err)
+			lastErr = err
+		}
+	}
+	if lastErr != nil {
+		return "", "", lastErr
+	}
+	return "", "", ErrNotFound
+}
+
+// parseBaseURL parses a server URL, mapping the hkp and hkps schemes onto HTTP.
+func parseBaseURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSuffix(raw, "/"))
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "hkp":
+		u.Scheme = "http"
+		if u.Port() == "" {
+			u.Host += ":11371"
+		}
+	case "hkps":
+		u.Scheme = "https"
+	case "http", "https":
+	default:
+		return nil, fmt.Errorf("unsupported upstream keyserver URL %q", raw)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("unsupported upstream keyserver URL %q", raw)
+	}
+	return u, nil
+}
+
+// get fetches a URL, mapping 404 onto ErrNotFound.
+func get(ctx context.Context, client *http.Client, u string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/pgp-keys, text/plain")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return "", ErrNotFound
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return "", fmt.Errorf("unexpected upstream response: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// HKPServer queries the /pks/lookup endpoint of a HKP keyserver.
+type HKPServer struct {
+	base   *url.URL
+	client *http.Client
+}
+
+func NewHKPServer(rawURL string, client *http.Client) (*HKPServer, error) {
+	base, err := parseBaseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return &HKPServer{base: base, client: client}, nil
+}
+
+func (h *HKPServer) URL() string {
+	return h.base.String()
+}
+
+func (h *HKPServer) Fetch(ctx context.Context, search string) (string, error) {
+	query := url.Values{"op": {"get"}, "options": {"mr"}, "search": {search}}
+	return get(ctx, h.client, h.base.String()+"/pks/lookup?"+query.Encode())
+}
+
+// VKSServer queries a Verifying Keyserver, such as keys.openpgp.org. VKS only looks up
+// full fingerprints, key IDs and email addresses.
+type VKSServer struct {
+	base   *url.URL
+	client *http.Client
+}
+
+func NewVKSServer(rawURL string, client *http.Client) (*VKSServer, error) {
+	base, err := parseBaseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return &VKSServer{base: base, client: client}, nil
+}
+
+func (v *VKSServer) URL() string {
+	return v.base.String()
+}
+
+func (v *VKSServer) Fetch(ctx context.Context, search string) (string, error) {
+	search = strings.ToLower(strings.TrimSpace(search))
+	id := strings.TrimPrefix(search, constants.GPGFingerprintPrefix)
+
+	var path string
+	switch {
+	case strings.Contains(search, "@"):
+		path = "/vks/v1/by-email/" + url.PathEscape(search)
+	case fingerprintPattern.MatchString(id):
+		path = "/vks/v1/by-fingerprint/" + strings.ToUpper(id)
+	case keyIDPattern.MatchString(id):
+		path = "/vks/v1/by-keyid/" + strings.ToUpper(id)
+	default:
+		return "", ErrNotFound
+	}
+	return get(ctx, v.client, v.base.String()+path)
+}
@@ -0,0 +1,157 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hibare/DomainHQ/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+const testKeyText = "-----BEGIN PGP PUBLIC KEY BLOCK-----\n"
+
+func TestHKPServer(t *testing.T) {
+	keyserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if r.URL.Path != "/pks/lookup" || query.Get("op") != "get" || query.Get("options") != "mr" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch query.Get("search") {
+		case "john@example.com":
+			fmt.Fprint(w, testKeyText)
+		case "broken@example.com":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer keyserver.Close()
+
+	server, err := NewHKPServer(keyserver.URL+"/", keyserver.Client())
+	assert.NoError(t, err)
+	assert.Equal(t, keyserver.URL, server.URL())
+
+	keyText, err := server.Fetch(context.Background(), "john@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, testKeyText, keyText)
+
+	_, err = server.Fetch(context.Background(), "bob@example.com")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = server.Fetch(context.Background(), "broken@example.com")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrNotFound)
+}
+
+func TestVKSServer(t *testing.T) {
+	paths := []string{}
+	keyserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.EscapedPath())
+		fmt.Fprint(w, testKeyText)
+	}))
+	defer keyserver.Close()
+
+	server, err := NewVKSServer(keyserver.URL, keyserver.Client())
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		Name   string
+		Search string
+		Path   string
+	}{
+		{Name: "Fingerprint", Search: "0x521496ac1a2208fd8c6313fb30926a1e9f378fb4", Path: "/vks/v1/by-fingerprint/521496AC1A2208FD8C6313FB30926A1E9F378FB4"},
+		{Name: "Key ID", Search: "0x30926A1E9F378FB4", Path: "/vks/v1/by-keyid/30926A1E9F378FB4"},
+		{Name: "Email", Search: "John+Keys@Example.com", Path: "/vks/v1/by-email/john+keys@example.com"},
+		{Name: "Name", Search: "John", Path: ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			paths = paths[:0]
+			keyText, err := server.Fetch(context.Background(), tc.Search)
+			if tc.Path == "" {
+				assert.ErrorIs(t, err, ErrNotFound)
+				assert.Empty(t, paths)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, testKeyText, keyText)
+			assert.Equal(t, []string{tc.Path}, paths)
+		})
+	}
+}
+
+func TestParseBaseURL(t *testing.T) {
+	testCases := []struct {
+		URL       string
+		Expected  string
+		ExpectErr bool
+	}{
+		{URL: "hkp://keys.example.com", Expected: "http://keys.example.com:11371"},
+		{URL: "hkp://keys.example.com:8080", Expected: "http://keys.example.com:8080"},
+		{URL: "hkps://keys.example.com/", Expected: "https://keys.example.com"},
+		{URL: "https://keys.example.com", Expected: "https://keys.example.com"},
+		{URL: "ldap://keys.example.com", ExpectErr: true},
+		{URL: "keys.example.com", ExpectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.URL, func(t *testing.T) {
+			u, err := parseBaseURL(tc.URL)
+			if tc.ExpectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.Expected, u.String())
+		})
+	}
+}
+
+func TestClient(t *testing.T) {
+	hkp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer hkp.Close()
+
+	vks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/vks/v1/by-email/john@example.com" {
+			fmt.Fprint(w, testKeyText)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer vks.Close()
+
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer down.Close()
+
+	client, err := New(config.UpstreamConfig{HKPServers: []string{down.URL, hkp.URL}, VKSServers: []string{vks.URL}, Timeout: time.Second})
+	assert.NoError(t, err)
+
+	keyText, source, err := client.Fetch(context.Background(), "john@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, testKeyText, keyText)
+	assert.Equal(t, vks.URL, source)
+
+	// A failing server is only reported when no other server had the key.
+	_, _, err = client.Fetch(context.Background(), "bob@example.com")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrNotFound)
+
+	client, err = New(config.UpstreamConfig{HKPServers: []string{hkp.URL}, Timeout: time.Second})
+	assert.NoError(t, err)
+	_, _, err = client.Fetch(context.Background(), "bob@example.com")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = New(config.UpstreamConfig{HKPServers: []string{"ftp://keys.example.com"}})
+	assert.Error(t, err)
+}
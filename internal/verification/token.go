@@ -0,0 +1,87 @@
+package verification
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("token expired")
+)
+
+// Token purposes. A token is only accepted by the endpoint it was issued for.
+const (
+	// PurposeVerify tokens are mailed to Email and publish it on the key when followed.
+	PurposeVerify = "verify"
+	// PurposeUpload tokens are returned by a VKS upload and let the uploader request
+	// verification of the key's addresses.
+	PurposeUpload = "upload"
+)
+
+// Token proves that the owner of Email asked for it to be published on the key Fingerprint,
+// or, for upload tokens, that the key was uploaded by whoever holds it.
+type Token struct {
+	Purpose     string `json:"purpose"`
+	Fingerprint string `json:"fpr"`
+	Email       string `json:"email,omitempty"`
+	ExpiresAt   int64  `json:"exp"`
+}
+
+func NewToken(purpose, fingerprint, email string, ttl time.Duration) *Token {
+	return &Token{
+		Purpose:     purpose,
+		Fingerprint: strings.ToLower(fingerprint),
+		Email:       strings.ToLower(email),
+		ExpiresAt:   time.Now().Add(ttl).Unix(),
+	}
+}
+
+func sign(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Sign encodes the token as "<payload>.<signature>", both base64url encoded.
+func (t *Token) Sign(secret string) (string, error) {
+	payload, err := json.Marshal(t)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + sign(secret, encoded), nil
+}
+
+// Parse checks the signature, expiry and purpose of a signed token. Verification tokens must
+// name an address.
+func Parse(secret, signed, purpose string) (*Token, error) {
+	encoded, signature, ok := strings.Cut(signed, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(sign(secret, encoded))) {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	t := &Token{}
+	if err := json.Unmarshal(payload, t); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if t.Purpose != purpose || (purpose == PurposeVerify && t.Email == "") {
+		return nil, ErrInvalidToken
+	}
+
+	if time.Now().Unix() > t.ExpiresAt {
+		return nil, ErrExpiredToken
+	}
+	return t, nil
+}
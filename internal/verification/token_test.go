@@ -0,0 +1,63 @@
+package verification
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testSecret = "test-secret"
+
+func TestToken(t *testing.T) {
+	signed, err := NewToken(PurposeVerify, "FF81F4CB6702D3218759DCA365607C5B5A86E5CC", "Example@Example.com", time.Hour).Sign(testSecret)
+	assert.NoError(t, err)
+
+	t.Run("Valid", func(t *testing.T) {
+		token, err := Parse(testSecret, signed, PurposeVerify)
+		assert.NoError(t, err)
+		assert.Equal(t, "ff81f4cb6702d3218759dca365607c5b5a86e5cc", token.Fingerprint)
+		assert.Equal(t, "example@example.com", token.Email)
+	})
+
+	t.Run("Wrong secret", func(t *testing.T) {
+		_, err := Parse("other-secret", signed, PurposeVerify)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		_, err := Parse(testSecret, "x"+signed, PurposeVerify)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+
+		_, err = Parse(testSecret, "garbage", PurposeVerify)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("Purpose", func(t *testing.T) {
+		_, err := Parse(testSecret, signed, PurposeUpload)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+
+		upload, err := NewToken(PurposeUpload, "FF81F4CB6702D3218759DCA365607C5B5A86E5CC", "", time.Hour).Sign(testSecret)
+		assert.NoError(t, err)
+		token, err := Parse(testSecret, upload, PurposeUpload)
+		assert.NoError(t, err)
+		assert.Equal(t, PurposeUpload, token.Purpose)
+
+		_, err = Parse(testSecret, upload, PurposeVerify)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+
+		// A verification token must name the address it publishes.
+		noEmail, err := NewToken(PurposeVerify, "FF81F4CB6702D3218759DCA365607C5B5A86E5CC", "", time.Hour).Sign(testSecret)
+		assert.NoError(t, err)
+		_, err = Parse(testSecret, noEmail, PurposeVerify)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		expired, err := NewToken(PurposeVerify, "FF81F4CB6702D3218759DCA365607C5B5A86E5CC", "example@example.com", -time.Minute).Sign(testSecret)
+		assert.NoError(t, err)
+
+		_, err = Parse(testSecret, expired, PurposeVerify)
+		assert.ErrorIs(t, err, ErrExpiredToken)
+	})
+}